@@ -1,62 +1,373 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 
 	"github.com/koenighotze/rag-demo/config"
+	"github.com/koenighotze/rag-demo/internal/app"
 	"github.com/koenighotze/rag-demo/internal/query"
-	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/koenighotze/rag-demo/internal/session"
+	"github.com/koenighotze/rag-demo/internal/vectordb"
 )
 
-type QueryFunction func(llm *ollama.LLM, guardRailLlm *ollama.LLM, query string) (string, error)
+type QueryFunction func(ctx context.Context, query string) (string, error)
 
-func createQueryHandler(llm *ollama.LLM, guardRailLlm *ollama.LLM, queryFunc QueryFunction) http.HandlerFunc {
+type RAGQueryFunction func(ctx context.Context, store vectordb.VectorStore, options query.RetrievalOptions, query string) (*query.Answer, error)
+
+type StreamFunction func(ctx context.Context, query string, sink query.TokenSink) (*query.StreamSummary, error)
+
+type SearchFunction func(ctx context.Context, store vectordb.VectorStore, search query.SearchQuery) ([]query.Hit, error)
+
+type ChatFunction func(ctx context.Context, store vectordb.VectorStore, request query.ChatRequest) (*query.ChatAnswer, error)
+
+type RAGStreamFunction func(ctx context.Context, store vectordb.VectorStore, options query.RetrievalOptions, query string, sink query.TokenSink) (*query.StreamSummary, error)
+
+type queryRequest struct {
+	Query string
+	// Collection selects the collection to retrieve from, the configured default is used if it is empty
+	Collection string
+	query.RetrievalOptions
+}
+
+// collections are the vector stores that can be queried, by collection name
+type collections struct {
+	defaultName string
+	stores      map[string]vectordb.VectorStore
+}
+
+func (c *collections) resolve(w http.ResponseWriter, name string) (vectordb.VectorStore, bool) {
+	if name == "" {
+		name = c.defaultName
+	}
+
+	store, ok := c.stores[name]
+	if !ok {
+		log.Printf("Unknown collection %s\n", name)
+		w.WriteHeader(http.StatusBadRequest)
+		//nolint:errcheck
+		fmt.Fprintf(w, "Unknown collection %s\n", name)
+		return nil, false
+	}
+	return store, true
+}
+
+func decodeQueryRequest(w http.ResponseWriter, r *http.Request) (*queryRequest, bool) {
+	var request queryRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		log.Printf("Cannot parse request body: %s\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+	if err := request.RetrievalOptions.Validate(); err != nil {
+		log.Printf("Invalid request: %s\n", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		//nolint:errcheck
+		fmt.Fprintf(w, "%s\n", err.Error())
+		return nil, false
+	}
+	return &request, true
+}
+
+func writeQueryError(w http.ResponseWriter, err error) {
+	var blocked *query.BlockedError
+	if errors.As(err, &blocked) {
+		log.Printf("The %s guardrail refused to answer. Categories: %v\n", blocked.Stage, blocked.Verdict.Categories)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		//nolint:errcheck
+		fmt.Fprintf(w, "Sorry, cannot answer your query! Reason: %s\n", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusInternalServerError)
+	log.Printf("Cannot generate answer: %s\n", err.Error())
+	//nolint:errcheck
+	fmt.Fprintf(w, "Sorry, cannot generate an answer at this time! Reason: %s\n", err.Error())
+}
+
+func createQueryHandler(queryFunc QueryFunction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, ok := decodeQueryRequest(w, r)
+		if !ok {
+			return
+		}
+
+		response, err := queryFunc(r.Context(), request.Query)
+		if err != nil {
+			writeQueryError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		log.Printf("Generated response: %s\n", response)
+		//nolint:errcheck
+		fmt.Fprintf(w, "%s", response)
+	}
+}
+
+func createRAGQueryHandler(collections *collections, queryFunc RAGQueryFunction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, ok := decodeQueryRequest(w, r)
+		if !ok {
+			return
+		}
+		store, ok := collections.resolve(w, request.Collection)
+		if !ok {
+			return
+		}
+
+		response, err := queryFunc(r.Context(), store, request.RetrievalOptions, request.Query)
+		if err != nil {
+			writeQueryError(w, err)
+			return
+		}
+
+		log.Printf("Generated response: %s with %d sources\n", response.Answer, len(response.Sources))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		//nolint:errcheck
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+type chatRequest struct {
+	query.ChatRequest
+	Collection string `json:"collection"`
+}
+
+func createChatHandler(collections *collections, chatFunc ChatFunction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			log.Printf("Cannot parse request body: %s\n", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := request.RetrievalOptions.Validate(); err != nil {
+			log.Printf("Invalid request: %s\n", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			//nolint:errcheck
+			fmt.Fprintf(w, "%s\n", err.Error())
+			return
+		}
+		store, ok := collections.resolve(w, request.Collection)
+		if !ok {
+			return
+		}
+
+		response, err := chatFunc(r.Context(), store, request.ChatRequest)
+		if errors.Is(err, session.ErrInvalidId) {
+			w.WriteHeader(http.StatusBadRequest)
+			//nolint:errcheck
+			fmt.Fprintf(w, "%s\n", err.Error())
+			return
+		}
+		if err != nil {
+			writeQueryError(w, err)
+			return
+		}
+
+		log.Printf("Answered in session %s with %d sources\n", response.SessionId, len(response.Sources))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		//nolint:errcheck
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func createDeleteSessionHandler(deleteFunc func(id string) error) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var request struct {
-			Query string
+		err := deleteFunc(r.PathValue("id"))
+		if errors.Is(err, session.ErrInvalidId) {
+			w.WriteHeader(http.StatusBadRequest)
+			//nolint:errcheck
+			fmt.Fprintf(w, "%s\n", err.Error())
+			return
 		}
-		err := json.NewDecoder(r.Body).Decode(&request)
 		if err != nil {
+			log.Printf("Cannot delete session: %s\n", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type searchRequest struct {
+	query.SearchQuery
+	Collection string `json:"collection"`
+}
+
+type searchResponse struct {
+	Hits []query.Hit `json:"hits"`
+}
+
+func createSearchHandler(collections *collections, searchFunc SearchFunction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request searchRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 			log.Printf("Cannot parse request body: %s\n", err.Error())
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		if err := request.SearchQuery.Validate(); err != nil {
+			log.Printf("Invalid request: %s\n", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			//nolint:errcheck
+			fmt.Fprintf(w, "%s\n", err.Error())
+			return
+		}
+		store, ok := collections.resolve(w, request.Collection)
+		if !ok {
+			return
+		}
 
-		response, err := queryFunc(llm, guardRailLlm, request.Query)
+		hits, err := searchFunc(r.Context(), store, request.SearchQuery)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Cannot generate answer: %s\n", err.Error())
+			log.Printf("Cannot search: %s\n", err.Error())
 			//nolint:errcheck
-			fmt.Fprintf(w, "Sorry, cannot generate an answer at this time! Reason: %s\n", err.Error())
+			fmt.Fprintf(w, "Sorry, cannot search at this time! Reason: %s\n", err.Error())
 			return
 		}
 
+		log.Printf("Found %d hits\n", len(hits))
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		log.Printf("Generated response: %s\n", response)
 		//nolint:errcheck
-		fmt.Fprintf(w, "%s", response)
+		json.NewEncoder(w).Encode(searchResponse{Hits: hits})
 	}
 }
 
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
+
+func createStreamingQueryHandler(streamFunc StreamFunction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, ok := decodeQueryRequest(w, r)
+		if !ok {
+			return
+		}
+
+		streamAnswer(w, func(sink query.TokenSink) (*query.StreamSummary, error) {
+			return streamFunc(r.Context(), request.Query, sink)
+		})
+	}
+}
+
+func createRAGStreamingQueryHandler(collections *collections, streamFunc RAGStreamFunction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, ok := decodeQueryRequest(w, r)
+		if !ok {
+			return
+		}
+		store, ok := collections.resolve(w, request.Collection)
+		if !ok {
+			return
+		}
+
+		streamAnswer(w, func(sink query.TokenSink) (*query.StreamSummary, error) {
+			return streamFunc(r.Context(), store, request.RetrievalOptions, request.Query, sink)
+		})
+	}
+}
+
+// streamAnswer sends every token of the answer as an event, followed by a final done or error event
+func streamAnswer(w http.ResponseWriter, stream func(sink query.TokenSink) (*query.StreamSummary, error)) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Println("Response writer does not support streaming")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	summary, err := stream(func(text string) error {
+		return writeEvent(w, flusher, "token", map[string]string{"text": text})
+	})
+	if err != nil {
+		log.Printf("Cannot stream answer: %s\n", err.Error())
+		event := map[string]any{"message": err.Error()}
+		var blocked *query.BlockedError
+		if errors.As(err, &blocked) {
+			event["guardrail"] = blocked.Verdict
+		}
+		//nolint:errcheck
+		writeEvent(w, flusher, "error", event)
+		return
+	}
+
+	log.Printf("Finished streaming with %d sources, guardrail decision: %s\n", len(summary.Sources), summary.Guardrail.Decision)
+	//nolint:errcheck
+	writeEvent(w, flusher, "done", summary)
+}
+
 func main() {
-	config := config.Default()
+	configPath := flag.String("config", config.DefaultPath(), "the json or yaml configuration file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
+
+	config, err := config.Load(*configPath)
+	if err != nil {
+		log.Default().Fatalln(err)
+	}
+	if *printConfig {
+		fmt.Println(config)
+		return
+	}
 	log.Println("Running with configuration: ", config)
 
-	llm, err := ollama.New(ollama.WithModel(config.Query.MainModel))
+	application, err := app.New(config)
 	if err != nil {
 		log.Default().Fatalln(err)
 	}
+	defer application.Close()
+
+	service, err := application.QueryService()
+	if err != nil {
+		log.Default().Fatalln(err)
+	}
+
+	stores, err := application.Collections()
+	if err != nil {
+		log.Default().Fatalln(err)
+	}
+	collections := &collections{
+		defaultName: config.Qdrant.CollectionName,
+		stores:      stores,
+	}
 
-	guardRailLlm, err := ollama.New(ollama.WithModel(config.Query.InputGuardrailModelName))
+	conversations, err := application.Conversations(service)
 	if err != nil {
 		log.Default().Fatalln(err)
 	}
 
-	http.HandleFunc("/query", createQueryHandler(llm, guardRailLlm, query.GeneratePlainAnswer))
-	http.HandleFunc("/ragquery", createQueryHandler(llm, guardRailLlm, query.GenerateAnswerWithRAG))
+	http.HandleFunc("/query", createQueryHandler(service.GeneratePlainAnswer))
+	http.HandleFunc("/ragquery", createRAGQueryHandler(collections, service.GenerateAnswerWithRAG))
+	http.HandleFunc("/search", createSearchHandler(collections, service.Search))
+	http.HandleFunc("/query/stream", createStreamingQueryHandler(service.StreamPlainAnswer))
+	http.HandleFunc("/ragquery/stream", createRAGStreamingQueryHandler(collections, service.StreamAnswerWithRAG))
+	http.HandleFunc("POST /chat", createChatHandler(collections, conversations.Chat))
+	http.HandleFunc("DELETE /chat/{id}", createDeleteSessionHandler(conversations.Delete))
 
 	fmt.Println("Starting server on ", config.ServerAddr)
 	log.Fatal(http.ListenAndServe(config.ServerAddr, nil))
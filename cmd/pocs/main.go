@@ -15,6 +15,11 @@ import (
 )
 
 func storeInQdrant(embedder embeddings.Embedder) (err error) {
+	embedding, err := embedder.EmbedDocuments(context.Background(), []string{"Fee fei fo famm"})
+	if err != nil {
+		return err
+	}
+
 	client, err := qdrant.NewClient(&qdrant.Config{
 		Host: "localhost",
 		Port: 6334,
@@ -39,7 +44,7 @@ func storeInQdrant(embedder embeddings.Embedder) (err error) {
 					For example: [0.1, -0.5, 0.8, 0.3] - that's 4 numbers
 					Important: This must match the actual size of embeddings your model produces
 				*/
-				Size: 786,
+				Size: uint64(len(embedding[0])),
 				/*
 					This determines how Qdrant calculates similarity between vectors
 					Cosine similarity measures the angle between vectors, not their length
@@ -50,11 +55,6 @@ func storeInQdrant(embedder embeddings.Embedder) (err error) {
 		})
 	}
 
-	embedding, err := embedder.EmbedDocuments(context.Background(), []string{"Fee fei fo famm"})
-	if err != nil {
-		return err
-	}
-
 	result, err := client.Upsert(context.Background(), &qdrant.UpsertPoints{
 		CollectionName: "rag",
 		Points: []*qdrant.PointStruct{
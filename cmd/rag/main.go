@@ -1,117 +1,126 @@
 package main
 
 import (
-	"io/fs"
+	"context"
+	"flag"
+	"fmt"
 	"log"
-	"path/filepath"
-	"strings"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/koenighotze/rag-demo/config"
+	"github.com/koenighotze/rag-demo/internal/app"
 	"github.com/koenighotze/rag-demo/internal/embedding"
+	"github.com/koenighotze/rag-demo/internal/ingest"
 	"github.com/koenighotze/rag-demo/internal/vectordb"
-	"github.com/ledongthuc/pdf"
 )
 
-func walkTextCorpus(vectorDbClient *vectordb.VectorDbClient) (embedding.Embedder, error) {
-	embedder := embedding.Default()
-
-	return embedder, filepath.WalkDir("text-data-corpus/", func(path string, d fs.DirEntry, err error) error {
-		log.Println("Walking on " + path)
-
-		if !d.Type().IsRegular() {
-			log.Printf("Skip %s. Is not a regular file", path)
-
-			return nil
-		}
+func searchForItem(ctx context.Context, embedder embedding.Embedder, vectorDbClient vectordb.VectorStore, query string) {
+	log.Println("SEARCHING FOR ", query)
 
-		if filepath.Ext(path) != ".pdf" {
-			log.Printf("Skip %s. Is not a PDF file", path)
+	item, err := embedder.EmbedDocument(ctx, query)
+	if err != nil {
+		return
+	}
 
-			return nil
-		}
+	searchResult, err := vectorDbClient.ExecuteSearch(item.Embedding, 1)
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("SEARCH RESULTS")
+	if len(searchResult) < 1 {
+		log.Println("NOTHING FOUND!!!!")
+		return
+	}
 
-		return extractTextChunksOnParagraphsFromPdf(vectorDbClient, embedder, path)
-	})
+	log.Printf("Searchresult values: Id %s, Score %f ", searchResult[0].Id, searchResult[0].Score)
+	log.Println(searchResult[0].Item)
 }
 
-func extractTextChunksOnParagraphsFromPdf(vectorDbClient *vectordb.VectorDbClient, embedder embedding.Embedder, path string) error {
-	log.Printf("Processing text in file %s", path)
-	file, reader, err := pdf.Open(path)
+// openCollection opens the collection with its manifest and vocabulary. A new collection holds none of the files
+// of the manifest, e.g. after -rebuild or if it was dropped, so every file is indexed again.
+func openCollection(application *app.App, name string, rebuild bool) (vectordb.VectorStore, *ingest.Manifest, *embedding.Vocabulary, error) {
+	client, err := application.Collection(name, rebuild)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
-	defer file.Close() //nolint:errcheck
-
-	// TODO optimize me (max string length and such)
-	var fullText strings.Builder
-	for pageNumber := range reader.NumPage() {
-		log.Printf("Working on page %d", pageNumber)
-
-		page := reader.Page(pageNumber)
-		text, err := page.GetPlainText(nil)
-		if err != nil {
-			log.Printf("Could not get text from page %d because of %s", pageNumber, err)
-			continue
-		}
-
-		fullText.WriteString(text)
-
-		if fullText.Len() >= 3000 {
-			log.Println("Max length of fulltext block reached. Should store chunks now...")
-			if err = storeChunks(vectorDbClient, embedder, path, fullText.String()); err != nil {
-				log.Printf("Cannot store chunks because of %s", err)
-			}
-			fullText.Reset()
-			continue
-		}
-	}
-	if err = storeChunks(vectorDbClient, embedder, path, fullText.String()); err != nil {
-		log.Printf("Cannot store chunks because of %s", err)
+	// the collection loaded or reset the vocabulary already
+	vocabulary, err := application.Vocabulary(name)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	return nil
-}
-
-func storeChunks(vectorDbClient *vectordb.VectorDbClient, embedder embedding.Embedder, path string, text string) error {
-	items, err := embedder.EmbedAllDocuments(path, text)
+	manifestPath := application.Config.PerCollection(application.Config.Ingestion.ManifestPath, name)
+	if client.Created() {
+		log.Printf("Collection %s is new, indexing all files", name)
+		return client, ingest.NewManifest(manifestPath), vocabulary, nil
+	}
+	manifest, err := ingest.LoadManifest(manifestPath)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
-	return vectorDbClient.AddPointsToCollection(items)
+	return client, manifest, vocabulary, nil
 }
 
-func searchForItem(embedder embedding.Embedder, vectorDbClient *vectordb.VectorDbClient, query string) {
-	log.Println("SEARCHING FOR ", query)
-
-	item, err := embedder.EmbedDocument(query)
+func main() {
+	configPath := flag.String("config", config.DefaultPath(), "the json or yaml configuration file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	inMemory := flag.Bool("in-memory", false, "index into an in-memory vector store instead of qdrant")
+	rebuild := flag.Bool("rebuild", false, "drop the collection and re-index the whole corpus")
+	collection := flag.String("collection", "", "the qdrant collection to index into, defaults to qdrant.collection_name")
+	corpusPath := flag.String("corpus", "", "the directory with the documents to index, defaults to ingestion.corpus_path")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
 	if err != nil {
+		log.Panic(err)
+	}
+	if *printConfig {
+		fmt.Println(cfg)
 		return
 	}
+	if *collection == "" {
+		*collection = cfg.Qdrant.CollectionName
+	}
+	if *corpusPath == "" {
+		*corpusPath = cfg.Ingestion.CorpusPath
+	}
 
-	searchResult, err := vectorDbClient.ExecuteSearch(item.Embedding)
+	application, err := app.New(cfg)
 	if err != nil {
-		panic(err)
+		log.Panic(err)
 	}
-	log.Printf("SEARCH RESULTS")
-	if len(searchResult) < 1 {
-		log.Println("NOTHING FOUND!!!!")
-		return
+	defer application.Close()
+
+	var client vectordb.VectorStore
+	// an in-memory store starts empty, so there is nothing to remember between runs
+	manifest := ingest.NewManifest("")
+	vocabulary := embedding.NewVocabulary("")
+	if *inMemory {
+		client = vectordb.NewInMemoryVectorStore(vocabulary)
+	} else {
+		client, manifest, vocabulary, err = openCollection(application, *collection, *rebuild)
 	}
+	if err != nil {
+		log.Panic(err)
+	}
+	defer client.Close()
 
-	log.Printf("Searchresult values: Id %d, Score %f ", searchResult[0].Id, searchResult[0].Score)
-	log.Println(searchResult[0].Item)
-}
-
-func main() {
-	client := vectordb.TruncatingVectorDbClient()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	embedder, err := walkTextCorpus(client)
+	err = application.Ingester(client, vocabulary, manifest).Run(ctx, *corpusPath)
+	if ctx.Err() != nil {
+		log.Println("Ingestion was interrupted, the remaining files will be indexed on the next run")
+		return
+	}
 	if err != nil {
 		log.Panic(err)
 	}
 
-	searchForItem(embedder, client, "Foo")
-	searchForItem(embedder, client, " What are the programs goals for moving of the mainframe?")
-	searchForItem(embedder, client, "The documentation had to be interpreted by  SMEs, but these individuals were spread too thinly across  multiple teams.")
-
-	defer client.Close()
+	embedder := application.Embedder
+	searchForItem(ctx, embedder, client, "Foo")
+	searchForItem(ctx, embedder, client, " What are the programs goals for moving of the mainframe?")
+	searchForItem(ctx, embedder, client, "The documentation had to be interpreted by  SMEs, but these individuals were spread too thinly across  multiple teams.")
 }
@@ -2,15 +2,13 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
-	"sync"
-)
+	"slices"
+	"strings"
 
-var (
-	once   sync.Once
-	config Config
-	err    error
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
@@ -18,64 +16,206 @@ type Config struct {
 	ServerAddr string    `json:"server_addr"`
 	Embedding  Embedding `json:"embedding"`
 	Qdrant     Qdrant    `json:"qdrant"`
+	Ingestion  Ingestion `json:"ingestion"`
+	Chat       Chat      `json:"chat"`
+}
+
+// Chat configures the conversations of the /chat endpoint
+type Chat struct {
+	// SessionStore is memory or file, SessionPath is the directory of the file store
+	SessionStore string `json:"session_store"`
+	SessionPath  string `json:"session_path"`
+	// HistoryTokenBudget is the size of the history sent with each message, older turns are summarized beyond it
+	HistoryTokenBudget int `json:"history_token_budget"`
+	// KeepMessages is the number of most recent messages that are never summarized
+	KeepMessages int `json:"keep_messages"`
+	// CondenseQueries rewrites follow-up questions into standalone search queries with the condense model,
+	// the main model is used if CondenseModelName is empty
+	CondenseQueries     bool    `json:"condense_queries"`
+	CondenseModelName   string  `json:"condense_model_name"`
+	CondenseTemperature float64 `json:"condense_temperature"`
 }
 
 type Qdrant struct {
-	Host           string `json:"host"`
-	Port           int    `json:"port"`
+	Host   string `json:"host"`
+	Port   int    `json:"port"`
+	APIKey string `json:"api_key" secret:"true"`
+	UseTLS bool   `json:"use_tls"`
+	// CollectionName is used if a request or the ingestion does not name a collection
 	CollectionName string `json:"collection_name"`
+	// Collections are further collections that can be queried besides CollectionName
+	Collections []string `json:"collections"`
+	Search      Search   `json:"search"`
+}
+
+// Search tunes recall against latency of the vector search
+type Search struct {
+	Exact          bool    `json:"exact"`
+	IndexedOnly    bool    `json:"indexed_only"`
+	ScoreThreshold float32 `json:"score_threshold"`
+	// BeamSize is the hnsw ef, qdrant picks it if it is 0
+	BeamSize uint64 `json:"beam_size"`
+	// Rescore re-scores the candidates found with quantized vectors with the original vectors, qdrant decides if it is not set
+	Rescore *bool `json:"quantization_rescore"`
+	// Oversampling pre-selects this many times the limit with quantized vectors before rescoring, 0 leaves it to qdrant
+	Oversampling float64 `json:"oversampling"`
+	// Hybrid fuses the dense results with bm25 keyword results by reciprocal rank fusion. The score threshold
+	// applies to the dense results before the fusion, results keep their similarity as score and get a fused_score.
+	Hybrid bool `json:"hybrid"`
+	// SparseWeight is the share of the bm25 ranking in the fused ranking, between 0 and 1
+	SparseWeight float64 `json:"sparse_weight"`
 }
 
 type Query struct {
-	MainModel                string  `json:"main_model_name"`
-	InputGuardrailModelName  string  `json:"input_guardrail_model_name"`
-	OutputGuardrailModelName string  `json:"output_guardrail_model_name"`
-	MainTemperature          float64 `json:"main_temperature"`
-	InputTemperature         float64 `json:"input_guardrail_temperature"`
-	OutputTemperature        float64 `json:"output_guardrail_temperature"`
+	MainModel                string    `json:"main_model_name"`
+	InputGuardrailModelName  string    `json:"input_guardrail_model_name"`
+	OutputGuardrailModelName string    `json:"output_guardrail_model_name"`
+	MainTemperature          float64   `json:"main_temperature"`
+	InputTemperature         float64   `json:"input_guardrail_temperature"`
+	OutputTemperature        float64   `json:"output_guardrail_temperature"`
+	InputGuardrailFormat     string    `json:"input_guardrail_format"`
+	OutputGuardrailFormat    string    `json:"output_guardrail_format"`
+	ContextGuardrailEnabled  bool      `json:"context_guardrail_enabled"`
+	TopK                     uint64    `json:"top_k"`
+	ContextTokenBudget       int       `json:"context_token_budget"`
+	StreamGuardrailMinChars  int       `json:"stream_guardrail_min_chars"`
+	Rerank                   Rerank    `json:"rerank"`
+	Mmr                      Mmr       `json:"mmr"`
+	Expansion                Expansion `json:"expansion"`
+	Providers                Providers `json:"providers"`
+}
+
+// Providers select the backend of each role. Rerank, expansion and condensing use the provider of the main model.
+type Providers struct {
+	Main            Provider `json:"main"`
+	InputGuardrail  Provider `json:"input_guardrail"`
+	OutputGuardrail Provider `json:"output_guardrail"`
+	Embedding       Provider `json:"embedding"`
+}
+
+type Provider struct {
+	// Type is one of ollama, openai for any openai compatible server, e.g. llama.cpp or vllm, or fake. Empty is ollama.
+	Type string `json:"type"`
+	// BaseUrl is the url of the server, empty uses the default of the type
+	BaseUrl string `json:"base_url"`
+	// ApiKeyEnv names the environment variable holding the api key of an openai server. Local servers need none.
+	ApiKeyEnv string `json:"api_key_env"`
+	// Responses are the scripted answers of the fake provider, it repeats them in turn
+	Responses []string `json:"responses"`
+}
+
+// Expansion rewrites the query before the retrieval, so short questions find chunks above the score threshold
+type Expansion struct {
+	// Mode is one of none, hyde or multi_query, a request can choose another mode
+	Mode string `json:"mode"`
+	// Queries is the number of paraphrases the multi_query mode searches for in addition to the query
+	Queries int `json:"queries"`
+	// ModelName and Temperature configure the model writing the expansions, the main model is used if ModelName is empty
+	ModelName   string  `json:"model_name"`
+	Temperature float64 `json:"temperature"`
+}
+
+// Mmr selects the context by maximal marginal relevance, so near duplicates do not crowd out other chunks
+type Mmr struct {
+	Enabled bool `json:"enabled"`
+	// Lambda trades relevance (1) against diversity (0)
+	Lambda float64 `json:"lambda"`
+	// Candidates is the number of chunks retrieved to select top_k from
+	Candidates uint64 `json:"candidates"`
+}
+
+// Rerank re-scores the retrieved chunks before they become the context
+type Rerank struct {
+	// Strategy is one of none, lexical or llm
+	Strategy string `json:"strategy"`
+	// Candidates is the number of chunks retrieved for re-ranking, the best top_k of them are kept
+	Candidates uint64 `json:"candidates"`
+	// ModelName and Temperature configure the llm strategy, the main model is used if ModelName is empty
+	ModelName   string  `json:"model_name"`
+	Temperature float64 `json:"temperature"`
+}
+
+type Ingestion struct {
+	CorpusPath   string `json:"corpus_path"`
+	ManifestPath string `json:"manifest_path"`
+	// VocabularyPath stores the bm25 vocabulary, the ingestion extends it and the queries need it for hybrid search
+	VocabularyPath string `json:"vocabulary_path"`
+	LoadWorkers    int    `json:"load_workers"`
+	SplitWorkers   int    `json:"split_workers"`
+	EmbedWorkers   int    `json:"embed_workers"`
+	UpsertWorkers  int    `json:"upsert_workers"`
+	EmbedBatchSize int    `json:"embed_batch_size"`
 }
 
 type Embedding struct {
 	ModelName string `json:"model_name"`
+	// ChunkStrategy is one of recursive, markdown or token
+	ChunkStrategy string `json:"chunk_strategy"`
+	// ChunkSize is measured in tokens for the token strategy and in characters otherwise
+	ChunkSize    int `json:"chunk_size"`
+	ChunkOverlap int `json:"chunk_overlap"`
 }
 
+// DefaultPath is the value of RAG_CONFIG or config.json in the working directory
 func DefaultPath() string {
+	if path := os.Getenv(envPrefix + "CONFIG"); path != "" {
+		return path
+	}
 	return "config.json"
 }
 
+// Load reads a json or yaml file, applies the environment overrides and validates the result
 func Load(path string) (Config, error) {
 	b, err := os.ReadFile(filepath.Clean(path))
 	if err != nil {
 		return Config{}, err
 	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if b, err = yamlToJson(b); err != nil {
+			return Config{}, fmt.Errorf("cannot parse %s: %w", path, err)
+		}
+	}
+
 	var cfg Config
 	if err := json.Unmarshal(b, &cfg); err != nil {
+		return Config{}, fmt.Errorf("cannot parse %s: %w", path, err)
+	}
+	if err := applyEnvOverrides(&cfg); err != nil {
 		return Config{}, err
 	}
-	// TODO Validation
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration in %s: %w", path, err)
+	}
 	return cfg, nil
 }
 
-func QdrantConfig() Qdrant {
-	return Default().Qdrant
-}
-
-func EmbeddingConfig() Embedding {
-	return Default().Embedding
+// yamlToJson converts yaml to json, so the json tags apply to both formats
+func yamlToJson(b []byte) ([]byte, error) {
+	var content map[string]any
+	if err := yaml.Unmarshal(b, &content); err != nil {
+		return nil, err
+	}
+	return json.Marshal(content)
 }
 
-func QueryConfig() Query {
-	return Default().Query
+// PerCollection keeps a file per collection, the configured path belongs to the default collection
+func (c Config) PerCollection(path string, collection string) string {
+	if collection == c.Qdrant.CollectionName {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + collection + ext
 }
 
-func Default() Config {
-	once.Do(func() {
-		config, err = Load(DefaultPath())
-	})
-
-	if err != nil {
-		panic(err)
+// CollectionNames returns the default collection first, followed by the other ones without duplicates
+func (q Qdrant) CollectionNames() []string {
+	names := []string{q.CollectionName}
+	for _, name := range q.Collections {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
 	}
-
-	return config
+	return names
 }
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the name of every environment variable, e.g. RAG_QDRANT_HOST for qdrant.host
+const envPrefix = "RAG_"
+
+const redacted = "*****"
+
+// EnvName is the environment variable that overrides the field with the dotted json path, e.g. qdrant.host
+func EnvName(path string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// applyEnvOverrides replaces every field whose environment variable is set. Lists are comma separated.
+func applyEnvOverrides(cfg *Config) error {
+	return walkFields(reflect.ValueOf(cfg).Elem(), "", func(path string, field reflect.Value, _ reflect.StructField) error {
+		value, ok := os.LookupEnv(EnvName(path))
+		if !ok {
+			return nil
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("%s: cannot use %q for %s: %w", EnvName(path), value, path, err)
+		}
+		return nil
+	})
+}
+
+// Redacted returns a copy with all secrets replaced, it is safe to log
+func (c Config) Redacted() Config {
+	//nolint:errcheck
+	walkFields(reflect.ValueOf(&c).Elem(), "", func(_ string, field reflect.Value, structField reflect.StructField) error {
+		if structField.Tag.Get("secret") == "true" && field.String() != "" {
+			field.SetString(redacted)
+		}
+		return nil
+	})
+	return c
+}
+
+func (c Config) String() string {
+	b, err := json.MarshalIndent(c.Redacted(), "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+// walkFields calls visit for every leaf field with its dotted json path
+func walkFields(value reflect.Value, prefix string, visit func(path string, field reflect.Value, structField reflect.StructField) error) error {
+	for i := range value.NumField() {
+		structField := value.Type().Field(i)
+		name, _, _ := strings.Cut(structField.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		path := prefix + name
+
+		field := value.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := walkFields(field, path+".", visit); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := visit(path, field, structField); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.Pointer:
+		value, err := newFieldValue(field.Type().Elem(), value)
+		if err != nil {
+			return err
+		}
+		field.Set(value)
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", field.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// newFieldValue parses the value into a new pointer for an optional field
+func newFieldValue(elemType reflect.Type, value string) (reflect.Value, error) {
+	pointer := reflect.New(elemType)
+	if err := setField(pointer.Elem(), value); err != nil {
+		return reflect.Value{}, err
+	}
+	return pointer, nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvOverrides(t *testing.T) {
+	t.Setenv("RAG_QDRANT_HOST", "qdrant.internal")
+	t.Setenv("RAG_QDRANT_PORT", "6335")
+	t.Setenv("RAG_QUERY_TOP_K", "7")
+	t.Setenv("RAG_QDRANT_SEARCH_SCORE_THRESHOLD", "0.5")
+	t.Setenv("RAG_QDRANT_SEARCH_HYBRID", "true")
+	t.Setenv("RAG_QDRANT_SEARCH_QUANTIZATION_RESCORE", "false")
+	t.Setenv("RAG_QDRANT_COLLECTIONS", "rag, archive,")
+
+	cfg := loadSample(t)
+
+	if cfg.Qdrant.Host != "qdrant.internal" || cfg.Qdrant.Port != 6335 {
+		t.Errorf("expected qdrant.internal:6335, got %s:%d", cfg.Qdrant.Host, cfg.Qdrant.Port)
+	}
+	if cfg.Query.TopK != 7 {
+		t.Errorf("expected top_k 7, got %d", cfg.Query.TopK)
+	}
+	if cfg.Qdrant.Search.ScoreThreshold != 0.5 || !cfg.Qdrant.Search.Hybrid {
+		t.Errorf("expected score threshold 0.5 with hybrid search, got %+v", cfg.Qdrant.Search)
+	}
+	if cfg.Qdrant.Search.Rescore == nil || *cfg.Qdrant.Search.Rescore {
+		t.Errorf("expected rescore to be set to false, got %v", cfg.Qdrant.Search.Rescore)
+	}
+	if len(cfg.Qdrant.Collections) != 2 || cfg.Qdrant.Collections[0] != "rag" || cfg.Qdrant.Collections[1] != "archive" {
+		t.Errorf("expected the collections rag and archive, got %q", cfg.Qdrant.Collections)
+	}
+}
+
+func TestEnvOverridesAreValidated(t *testing.T) {
+	t.Setenv("RAG_QDRANT_PORT", "70000")
+	if _, err := Load("../config.json"); err == nil {
+		t.Error("expected an error for port 70000")
+	}
+
+	t.Setenv("RAG_QDRANT_PORT", "many")
+	if _, err := Load("../config.json"); err == nil {
+		t.Error("expected an error for a port that is not a number")
+	}
+}
+
+// json is valid yaml, so the printed configuration loads as a yaml file
+func TestLoadYaml(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := loadSample(t)
+	cfg.Qdrant.Host = "from-yaml"
+	if err := os.WriteFile(path, []byte(cfg.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Qdrant.Host != "from-yaml" {
+		t.Errorf("expected host from-yaml, got %s", loaded.Qdrant.Host)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := loadSample(t)
+	cfg.Qdrant.APIKey = "secret-key"
+
+	if redacted := cfg.Redacted(); redacted.Qdrant.APIKey != "*****" {
+		t.Errorf("expected the api key to be redacted, got %s", redacted.Qdrant.APIKey)
+	}
+	if cfg.Qdrant.APIKey != "secret-key" {
+		t.Error("Redacted must not change the original configuration")
+	}
+}
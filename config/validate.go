@@ -0,0 +1,165 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FieldError describes an invalid field by its dotted json path, e.g. qdrant.port
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+const (
+	// maxTopK bounds the chunks of a context, more do not fit into the context of the local models anyway
+	maxTopK = 100
+	// maxOverFetch bounds the candidates of rerank and mmr as a multiple of top_k, the default is 4
+	maxOverFetch = 10
+)
+
+type validator struct {
+	errs []error
+}
+
+func (v *validator) fail(field string, format string, args ...any) {
+	v.errs = append(v.errs, &FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) required(field string, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.fail(field, "must not be empty")
+	}
+}
+
+func (v *validator) port(field string, port int) {
+	if port < 1 || port > 65535 {
+		v.fail(field, "must be between 1 and 65535, got %d", port)
+	}
+}
+
+func (v *validator) temperature(field string, temperature float64) {
+	if temperature < 0 || temperature > 2 {
+		v.fail(field, "must be between 0 and 2, got %g", temperature)
+	}
+}
+
+func (v *validator) notNegative(field string, value int) {
+	if value < 0 {
+		v.fail(field, "must not be negative, got %d", value)
+	}
+}
+
+func (v *validator) oneOf(field string, value string, allowed ...string) {
+	if slices.Contains(allowed, value) {
+		return
+	}
+	v.fail(field, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+}
+
+// candidates checks the number of chunks over-fetched to select top_k from, 0 picks the default.
+// A missing top_k is reported on its own.
+func (v *validator) candidates(field string, candidates uint64, topK uint64) {
+	if candidates == 0 || topK == 0 {
+		return
+	}
+	if candidates < topK || candidates > maxOverFetch*topK {
+		v.fail(field, "must be 0 or between top_k %d and %d, got %d", topK, maxOverFetch*topK, candidates)
+	}
+}
+
+func (v *validator) provider(field string, provider Provider) {
+	v.oneOf(field+".type", provider.Type, "", "ollama", "openai", "fake")
+}
+
+// Validate reports every invalid field, the errors are joined and each one is a *FieldError
+func (c Config) Validate() error {
+	v := &validator{}
+
+	if _, port, err := net.SplitHostPort(c.ServerAddr); err != nil {
+		v.fail("server_addr", "must be host:port or :port, got %q", c.ServerAddr)
+	} else if p, err := strconv.Atoi(port); err != nil {
+		v.fail("server_addr", "port must be a number, got %q", port)
+	} else {
+		v.port("server_addr", p)
+	}
+
+	v.required("query.main_model_name", c.Query.MainModel)
+	v.required("query.input_guardrail_model_name", c.Query.InputGuardrailModelName)
+	v.required("query.output_guardrail_model_name", c.Query.OutputGuardrailModelName)
+	v.temperature("query.main_temperature", c.Query.MainTemperature)
+	v.temperature("query.input_guardrail_temperature", c.Query.InputTemperature)
+	v.temperature("query.output_guardrail_temperature", c.Query.OutputTemperature)
+	v.oneOf("query.input_guardrail_format", c.Query.InputGuardrailFormat, "", "llama-guard", "json")
+	v.oneOf("query.output_guardrail_format", c.Query.OutputGuardrailFormat, "", "llama-guard", "json")
+	if c.Query.TopK < 1 || c.Query.TopK > maxTopK {
+		v.fail("query.top_k", "must be between 1 and %d, got %d", maxTopK, c.Query.TopK)
+	}
+	v.notNegative("query.context_token_budget", c.Query.ContextTokenBudget)
+	v.notNegative("query.stream_guardrail_min_chars", c.Query.StreamGuardrailMinChars)
+	v.oneOf("query.rerank.strategy", c.Query.Rerank.Strategy, "", "none", "lexical", "llm")
+	v.temperature("query.rerank.temperature", c.Query.Rerank.Temperature)
+	v.candidates("query.rerank.candidates", c.Query.Rerank.Candidates, c.Query.TopK)
+	v.provider("query.providers.main", c.Query.Providers.Main)
+	v.provider("query.providers.input_guardrail", c.Query.Providers.InputGuardrail)
+	v.provider("query.providers.output_guardrail", c.Query.Providers.OutputGuardrail)
+	v.provider("query.providers.embedding", c.Query.Providers.Embedding)
+	v.oneOf("query.expansion.mode", c.Query.Expansion.Mode, "", "none", "hyde", "multi_query")
+	v.notNegative("query.expansion.queries", c.Query.Expansion.Queries)
+	v.temperature("query.expansion.temperature", c.Query.Expansion.Temperature)
+	if c.Query.Mmr.Lambda < 0 || c.Query.Mmr.Lambda > 1 {
+		v.fail("query.mmr.lambda", "must be between 0 and 1, got %g", c.Query.Mmr.Lambda)
+	}
+	v.candidates("query.mmr.candidates", c.Query.Mmr.Candidates, c.Query.TopK)
+
+	v.required("embedding.model_name", c.Embedding.ModelName)
+	v.oneOf("embedding.chunk_strategy", c.Embedding.ChunkStrategy, "", "recursive", "markdown", "token")
+	v.notNegative("embedding.chunk_size", c.Embedding.ChunkSize)
+	v.notNegative("embedding.chunk_overlap", c.Embedding.ChunkOverlap)
+	if c.Embedding.ChunkSize > 0 && c.Embedding.ChunkOverlap >= c.Embedding.ChunkSize {
+		v.fail("embedding.chunk_overlap", "must be smaller than the chunk size %d, got %d", c.Embedding.ChunkSize, c.Embedding.ChunkOverlap)
+	}
+
+	v.required("qdrant.host", c.Qdrant.Host)
+	v.port("qdrant.port", c.Qdrant.Port)
+	v.required("qdrant.collection_name", c.Qdrant.CollectionName)
+	for i, name := range c.Qdrant.Collections {
+		v.required(fmt.Sprintf("qdrant.collections[%d]", i), name)
+	}
+
+	if c.Qdrant.Search.ScoreThreshold < -1 || c.Qdrant.Search.ScoreThreshold > 1 {
+		v.fail("qdrant.search.score_threshold", "must be between -1 and 1, got %g", c.Qdrant.Search.ScoreThreshold)
+	}
+	if c.Qdrant.Search.Oversampling != 0 && c.Qdrant.Search.Oversampling < 1 {
+		v.fail("qdrant.search.oversampling", "must be 0 or at least 1, got %g", c.Qdrant.Search.Oversampling)
+	}
+	if c.Qdrant.Search.SparseWeight < 0 || c.Qdrant.Search.SparseWeight > 1 {
+		v.fail("qdrant.search.sparse_weight", "must be between 0 and 1, got %g", c.Qdrant.Search.SparseWeight)
+	}
+
+	v.required("ingestion.corpus_path", c.Ingestion.CorpusPath)
+	v.required("ingestion.vocabulary_path", c.Ingestion.VocabularyPath)
+	v.notNegative("ingestion.load_workers", c.Ingestion.LoadWorkers)
+	v.notNegative("ingestion.split_workers", c.Ingestion.SplitWorkers)
+	v.notNegative("ingestion.embed_workers", c.Ingestion.EmbedWorkers)
+	v.notNegative("ingestion.upsert_workers", c.Ingestion.UpsertWorkers)
+	v.notNegative("ingestion.embed_batch_size", c.Ingestion.EmbedBatchSize)
+
+	v.oneOf("chat.session_store", c.Chat.SessionStore, "", "memory", "file")
+	if c.Chat.SessionStore == "file" {
+		v.required("chat.session_path", c.Chat.SessionPath)
+	}
+	v.notNegative("chat.history_token_budget", c.Chat.HistoryTokenBudget)
+	v.notNegative("chat.keep_messages", c.Chat.KeepMessages)
+	v.temperature("chat.condense_temperature", c.Chat.CondenseTemperature)
+
+	return errors.Join(v.errs...)
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func loadSample(t *testing.T) Config {
+	t.Helper()
+	cfg, err := Load("../config.json")
+	if err != nil {
+		t.Fatalf("the sample configuration does not load: %s", err)
+	}
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		// fields are the fields that must be reported, none for a valid configuration
+		fields []string
+	}{
+		{"sample", func(*Config) {}, nil},
+		{"empty guardrail formats default to llama-guard", func(c *Config) {
+			c.Query.InputGuardrailFormat = ""
+			c.Query.OutputGuardrailFormat = ""
+		}, nil},
+		{"unknown guardrail format", func(c *Config) { c.Query.InputGuardrailFormat = "xml" }, []string{"query.input_guardrail_format"}},
+		{"no top_k", func(c *Config) { c.Query.TopK = 0 }, []string{"query.top_k"}},
+		{"top_k above the maximum", func(c *Config) {
+			c.Query.TopK = maxTopK + 1
+			c.Query.Rerank.Candidates = 0
+			c.Query.Mmr.Candidates = 0
+		}, []string{"query.top_k"}},
+		{"temperature out of range", func(c *Config) { c.Query.MainTemperature = 2.5 }, []string{"query.main_temperature"}},
+		{"server address without port", func(c *Config) { c.ServerAddr = "localhost" }, []string{"server_addr"}},
+		{"overlap as large as the chunk", func(c *Config) {
+			c.Embedding.ChunkSize = 100
+			c.Embedding.ChunkOverlap = 100
+		}, []string{"embedding.chunk_overlap"}},
+		{"rerank candidates below top_k", func(c *Config) { c.Query.Rerank.Candidates = c.Query.TopK - 1 }, []string{"query.rerank.candidates"}},
+		{"rerank candidates far above top_k", func(c *Config) { c.Query.Rerank.Candidates = maxOverFetch*c.Query.TopK + 1 }, []string{"query.rerank.candidates"}},
+		{"default rerank candidates", func(c *Config) { c.Query.Rerank.Candidates = 0 }, nil},
+		{"mmr lambda above 1", func(c *Config) { c.Query.Mmr.Lambda = 1.5 }, []string{"query.mmr.lambda"}},
+		{"mmr candidates below top_k", func(c *Config) { c.Query.Mmr.Candidates = 1 }, []string{"query.mmr.candidates"}},
+		{"mmr candidates far above top_k", func(c *Config) { c.Query.Mmr.Candidates = maxOverFetch*c.Query.TopK + 1 }, []string{"query.mmr.candidates"}},
+		{"unknown expansion mode", func(c *Config) { c.Query.Expansion.Mode = "all" }, []string{"query.expansion.mode"}},
+		{"unknown provider", func(c *Config) { c.Query.Providers.Embedding.Type = "cohere" }, []string{"query.providers.embedding.type"}},
+		{"file session store without path", func(c *Config) {
+			c.Chat.SessionStore = "file"
+			c.Chat.SessionPath = ""
+		}, []string{"chat.session_path"}},
+		{"every invalid field is reported", func(c *Config) {
+			c.Qdrant.Host = ""
+			c.Qdrant.Port = 0
+			c.Qdrant.Search.SparseWeight = -0.1
+		}, []string{"qdrant.host", "qdrant.port", "qdrant.search.sparse_weight"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadSample(t)
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			reported := map[string]bool{}
+			for _, e := range unwrapJoined(err) {
+				var fieldErr *FieldError
+				if !errors.As(e, &fieldErr) {
+					t.Fatalf("expected a *FieldError, got %T: %s", e, e)
+				}
+				reported[fieldErr.Field] = true
+			}
+
+			if len(reported) != len(tt.fields) {
+				t.Errorf("expected errors for %v, got %v", tt.fields, err)
+			}
+			for _, field := range tt.fields {
+				if !reported[field] {
+					t.Errorf("expected an error for %s, got %v", field, err)
+				}
+			}
+		})
+	}
+}
+
+func unwrapJoined(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"context"
+	"log"
+
+	"github.com/koenighotze/rag-demo/config"
+	"github.com/koenighotze/rag-demo/internal/embedding"
+	"github.com/koenighotze/rag-demo/internal/ingest"
+	"github.com/koenighotze/rag-demo/internal/provider"
+	"github.com/koenighotze/rag-demo/internal/query"
+	"github.com/koenighotze/rag-demo/internal/session"
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// App wires the components of a single configuration. The connection to qdrant is opened on first use.
+// Wiring is not safe for concurrent use, the components it returns are.
+type App struct {
+	Config   config.Config
+	Embedder embedding.Embedder
+
+	dimension    uint64
+	connection   *vectordb.Connection
+	vocabularies map[string]*embedding.Vocabulary
+}
+
+func New(cfg config.Config) (*App, error) {
+	client, err := provider.NewEmbedderClient(cfg.Query.Providers.Embedding, cfg.Embedding.ModelName)
+	if err != nil {
+		return nil, err
+	}
+	embedder, err := embedding.NewEmbedder(cfg.Embedding, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &App{
+		Config:       cfg,
+		Embedder:     embedder,
+		vocabularies: map[string]*embedding.Vocabulary{},
+	}, nil
+}
+
+// Dimension is the vector size of the embedding model, it is measured once
+func (a *App) Dimension() (uint64, error) {
+	if a.dimension > 0 {
+		return a.dimension, nil
+	}
+
+	dimension, err := a.Embedder.Dimension(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("The embedding model produces vectors of size %d", dimension)
+	a.dimension = dimension
+	return dimension, nil
+}
+
+// Vocabulary loads the bm25 vocabulary of the collection once.
+// The vocabulary is only read on load, so a running server does not see the terms of later ingestions.
+func (a *App) Vocabulary(collection string) (*embedding.Vocabulary, error) {
+	if vocabulary, ok := a.vocabularies[collection]; ok {
+		return vocabulary, nil
+	}
+
+	vocabulary, err := embedding.LoadVocabulary(a.Config.PerCollection(a.Config.Ingestion.VocabularyPath, collection))
+	if err != nil {
+		return nil, err
+	}
+	a.vocabularies[collection] = vocabulary
+	return vocabulary, nil
+}
+
+// Collection opens the named qdrant collection, see vectordb.Connection.Collection. If the collection is created,
+// e.g. with truncate, the vocabulary is reset and replaces the stored one on save.
+func (a *App) Collection(name string, truncate bool) (*vectordb.VectorDbClient, error) {
+	dimension, err := a.Dimension()
+	if err != nil {
+		return nil, err
+	}
+	vocabulary, err := a.Vocabulary(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.connection == nil {
+		connection, err := vectordb.Connect(a.Config.Qdrant)
+		if err != nil {
+			return nil, err
+		}
+		a.connection = connection
+	}
+	client, err := a.connection.Collection(name, dimension, truncate, vocabulary)
+	if err != nil {
+		return nil, err
+	}
+	if client.Created() {
+		vocabulary.Reset()
+	}
+	return client, nil
+}
+
+// Collections opens the default and all further configured collections by name
+func (a *App) Collections() (map[string]vectordb.VectorStore, error) {
+	stores := map[string]vectordb.VectorStore{}
+	for _, name := range a.Config.Qdrant.CollectionNames() {
+		store, err := a.Collection(name, false)
+		if err != nil {
+			return nil, err
+		}
+		stores[name] = store
+	}
+	return stores, nil
+}
+
+func (a *App) QueryService() (*query.Service, error) {
+	providers := a.Config.Query.Providers
+	llm, err := provider.NewModel(providers.Main, a.Config.Query.MainModel)
+	if err != nil {
+		return nil, err
+	}
+
+	inputGuardrailLlm, err := provider.NewModel(providers.InputGuardrail, a.Config.Query.InputGuardrailModelName)
+	if err != nil {
+		return nil, err
+	}
+
+	outputGuardrailLlm, err := provider.NewModel(providers.OutputGuardrail, a.Config.Query.OutputGuardrailModelName)
+	if err != nil {
+		return nil, err
+	}
+
+	// the mode can be chosen per request, so the expansion model is needed even if query.expansion.mode is none
+	expansionLlm := llm
+	if model := a.Config.Query.Expansion.ModelName; model != "" {
+		if expansionLlm, err = a.mainProviderModel(model); err != nil {
+			return nil, err
+		}
+	}
+
+	reranker, err := a.reranker()
+	if err != nil {
+		return nil, err
+	}
+
+	return query.NewService(llm, inputGuardrailLlm, outputGuardrailLlm, expansionLlm, reranker, a.Embedder, a.Config.Query), nil
+}
+
+// mainProviderModel connects to a model served by the provider of the main model, the main model itself if model is empty
+func (a *App) mainProviderModel(model string) (llms.Model, error) {
+	if model == "" {
+		model = a.Config.Query.MainModel
+	}
+	return provider.NewModel(a.Config.Query.Providers.Main, model)
+}
+
+// reranker returns nil if the retrieved chunks are not re-ranked
+func (a *App) reranker() (query.Reranker, error) {
+	rerank := a.Config.Query.Rerank
+	switch rerank.Strategy {
+	case query.RerankLexical:
+		return query.NewLexicalReranker(), nil
+	case query.RerankLlm:
+		llm, err := a.mainProviderModel(rerank.ModelName)
+		if err != nil {
+			return nil, err
+		}
+		return query.NewLlmReranker(llm, rerank.Temperature), nil
+	default:
+		return nil, nil
+	}
+}
+
+// Conversations keeps the chat sessions in the configured store
+func (a *App) Conversations(service *query.Service) (*query.Conversations, error) {
+	sessions, err := session.NewStore(a.Config.Chat)
+	if err != nil {
+		return nil, err
+	}
+
+	var condenseLlm llms.Model
+	if a.Config.Chat.CondenseQueries {
+		if condenseLlm, err = a.mainProviderModel(a.Config.Chat.CondenseModelName); err != nil {
+			return nil, err
+		}
+	}
+	return query.NewConversations(service, condenseLlm, sessions, a.Config.Chat), nil
+}
+
+func (a *App) Ingester(store vectordb.VectorStore, vocabulary *embedding.Vocabulary, manifest *ingest.Manifest) *ingest.Ingester {
+	return ingest.NewIngester(store, a.Embedder, vocabulary, ingest.DefaultRegistry(), manifest, a.Config.Ingestion)
+}
+
+func (a *App) Close() {
+	if a.connection != nil {
+		a.connection.Close()
+		a.connection = nil
+	}
+}
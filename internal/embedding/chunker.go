@@ -0,0 +1,79 @@
+package embedding
+
+import (
+	"fmt"
+
+	"github.com/koenighotze/rag-demo/config"
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+const (
+	// ChunkRecursive splits by paragraph, then line, then sentence and finally by word
+	ChunkRecursive = "recursive"
+	// ChunkMarkdown splits along the markdown headings and keeps the heading hierarchy in every chunk
+	ChunkMarkdown = "markdown"
+	// ChunkToken cuts the text into windows of a fixed number of tokens
+	ChunkToken = "token"
+)
+
+const (
+	defaultChunkSize   = 1000
+	defaultChunkTokens = 512
+)
+
+// Chunker splits the text of a document into the chunks that get embedded
+type Chunker interface {
+	Split(text string) ([]string, error)
+}
+
+type splitterChunker struct {
+	splitter textsplitter.TextSplitter
+}
+
+func (c splitterChunker) Split(text string) ([]string, error) {
+	return c.splitter.SplitText(text)
+}
+
+// NewChunker creates the chunker for the configured strategy. The chunk size is measured in characters,
+// except for the token strategy. A size of 0 picks a default, the token default fits models with a 512 token window.
+func NewChunker(config config.Embedding) (Chunker, error) {
+	strategy := config.ChunkStrategy
+	if strategy == "" {
+		strategy = ChunkRecursive
+	}
+
+	size := config.ChunkSize
+	if size <= 0 {
+		size = defaultChunkSize
+		if strategy == ChunkToken {
+			size = defaultChunkTokens
+		}
+	}
+	overlap := config.ChunkOverlap
+	if overlap < 0 || overlap >= size {
+		return nil, fmt.Errorf("chunk overlap %d must be between 0 and the chunk size %d", overlap, size)
+	}
+
+	switch strategy {
+	case ChunkRecursive:
+		return splitterChunker{textsplitter.NewRecursiveCharacter(
+			textsplitter.WithSeparators([]string{"\n\n", "\n", ". ", "? ", "! ", " ", ""}),
+			textsplitter.WithChunkSize(size),
+			textsplitter.WithChunkOverlap(overlap),
+		)}, nil
+	case ChunkMarkdown:
+		return splitterChunker{textsplitter.NewMarkdownTextSplitter(
+			textsplitter.WithChunkSize(size),
+			textsplitter.WithChunkOverlap(overlap),
+			textsplitter.WithHeadingHierarchy(true),
+			textsplitter.WithCodeBlocks(true),
+		)}, nil
+	case ChunkToken:
+		return splitterChunker{textsplitter.NewTokenSplitter(
+			textsplitter.WithChunkSize(size),
+			textsplitter.WithChunkOverlap(overlap),
+		)}, nil
+	default:
+		return nil, fmt.Errorf("unknown chunk strategy %q, use %q, %q or %q", config.ChunkStrategy, ChunkRecursive, ChunkMarkdown, ChunkToken)
+	}
+}
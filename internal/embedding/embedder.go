@@ -2,92 +2,118 @@ package embedding
 
 import (
 	"context"
-	"log"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/koenighotze/rag-demo/config"
 	"github.com/tmc/langchaingo/embeddings"
-	"github.com/tmc/langchaingo/llms/ollama"
-	"github.com/tmc/langchaingo/textsplitter"
 )
 
 type KnowledgeItem struct {
+	// Id is the point id in the vector store. A random id is used if it is empty.
+	Id             string
 	Embedding      []float32
 	SourceDocument string
-	Chunk          string
+	Title          string
+	// StartPage and EndPage are the pages the chunk starts and ends on. Both are 0 for formats without pages.
+	StartPage int
+	EndPage   int
+	// ChunkIndex is the position of the chunk within its document
+	ChunkIndex int
+	// StartOffset and EndOffset are the character offsets of the chunk in the text of its document
+	StartOffset int
+	EndOffset   int
+	Chunk       string
+	// DocumentType is the format of the source document, e.g. pdf or markdown
+	DocumentType string
+	// Tags are the directory names of the source document below the corpus root
+	Tags       []string
+	IngestedAt time.Time
+	// Sparse are the bm25 weights of the chunk for hybrid search
+	Sparse SparseVector
 }
 
 type Embedder struct {
 	embedder embeddings.Embedder
+	chunker  Chunker
 }
 
-func (e *Embedder) EmbedDocument(text string) (*KnowledgeItem, error) {
-	embedding, err := e.embedder.EmbedDocuments(context.Background(), []string{text})
+func (e *Embedder) EmbedDocument(ctx context.Context, text string) (*KnowledgeItem, error) {
+	embedding, err := e.embedder.EmbedDocuments(ctx, []string{text})
 
 	if err != nil {
 		return nil, err
 	}
 
-	return embeddingToKowledgeItem(embedding[0], "", text), nil
+	return &KnowledgeItem{
+		Embedding: embedding[0],
+		Chunk:     text,
+	}, nil
 }
 
-func (e *Embedder) EmbedAllDocuments(path string, text string) ([]*KnowledgeItem, error) {
-	if len(text) <= 0 {
-		return []*KnowledgeItem{}, nil
-	}
-
-	chunks, err := textsplitter.NewTokenSplitter().SplitText(text)
+// Dimension measures the size of the vectors the model produces by embedding a short probe text
+func (e *Embedder) Dimension(ctx context.Context) (uint64, error) {
+	embeds, err := e.embedder.EmbedDocuments(ctx, []string{"dimension probe"})
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	embeds, err := e.embedder.EmbedDocuments(context.Background(), chunks)
-	log.Printf("Generated embeddings with size %d for text of length %d", len(embeds), len(text))
-	if err != nil {
-		return nil, err
+	if len(embeds) != 1 || len(embeds[0]) == 0 {
+		return 0, errors.New("the embedding model returned no vector for the dimension probe")
 	}
-
-	return embeddingsToKnowledgeItems(embeds, path, chunks), nil
+	return uint64(len(embeds[0])), nil
 }
 
-func embeddingToKowledgeItem(embedding []float32, sourceDocument string, chunk string) *KnowledgeItem {
-	return &KnowledgeItem{
-		Embedding:      embedding,
-		Chunk:          chunk,
-		SourceDocument: sourceDocument,
-	}
+// SplitText cuts the text into chunks with the configured chunking strategy
+func (e *Embedder) SplitText(text string) ([]string, error) {
+	return e.chunker.Split(text)
 }
 
-func embeddingsToKnowledgeItems(embeds [][]float32, sourceDocument string, chunks []string) []*KnowledgeItem {
-	var items []*KnowledgeItem
-	for i, e := range embeds {
-		items = append(items, embeddingToKowledgeItem(e, sourceDocument, chunks[i]))
+// EmbedItems computes the embeddings for the chunks of all items with a single call to the model
+func (e *Embedder) EmbedItems(ctx context.Context, items []*KnowledgeItem) error {
+	var chunks []string
+	for _, item := range items {
+		chunks = append(chunks, item.Chunk)
 	}
-	return items
-}
 
-func newEmbedderModel(embedderModelName string) *ollama.LLM {
-	llm, err := ollama.New(ollama.WithModel(embedderModelName))
+	embeds, err := e.embedder.EmbedDocuments(ctx, chunks)
 	if err != nil {
-		log.Fatalln(err)
+		return err
+	}
+	if len(embeds) != len(items) {
+		return fmt.Errorf("expected %d embeddings but got %d", len(items), len(embeds))
+	}
 
+	for i, item := range items {
+		item.Embedding = embeds[i]
 	}
-	return llm
+	return nil
 }
 
-func newEmbedder(embedderClient embeddings.EmbedderClient) embeddings.Embedder {
-	embedder, err := embeddings.NewEmbedder(embedderClient, embeddings.WithStripNewLines(true))
+// ChunkId derives a stable point id from the source document, the position of the chunk and its content
+func ChunkId(sourceDocument string, position int, chunk string) string {
+	contentHash := sha256.Sum256([]byte(chunk))
+	name := fmt.Sprintf("%s#%d#%s", sourceDocument, position, hex.EncodeToString(contentHash[:]))
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(name)).String()
+}
 
+// NewEmbedder embeds with the model of the client, e.g. an ollama or openai llm
+func NewEmbedder(config config.Embedding, client embeddings.EmbedderClient) (Embedder, error) {
+	chunker, err := NewChunker(config)
 	if err != nil {
-		log.Fatalln(err)
+		return Embedder{}, err
 	}
-	return embedder
-}
 
-func NewEmbedder(config config.Embedding) Embedder {
-	return Embedder{
-		embedder: newEmbedder(newEmbedderModel(config.ModelName)),
+	embedder, err := embeddings.NewEmbedder(client, embeddings.WithStripNewLines(true))
+	if err != nil {
+		return Embedder{}, err
 	}
-}
 
-func Default() Embedder {
-	return NewEmbedder(config.Default().Embedding)
+	return Embedder{
+		embedder: embedder,
+		chunker:  chunker,
+	}, nil
 }
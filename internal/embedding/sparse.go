@@ -0,0 +1,250 @@
+package embedding
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// the usual bm25 parameters, k1 saturates the term frequency and b normalizes by the chunk length
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SparseVector holds the weights of the terms of a text, the indices are the term ids of a Vocabulary
+type SparseVector struct {
+	Indices []uint32
+	Values  []float32
+}
+
+func (v SparseVector) IsEmpty() bool {
+	return len(v.Indices) == 0
+}
+
+// Dot is the bm25 score if v encodes a chunk and other encodes a query
+func (v SparseVector) Dot(other SparseVector) float32 {
+	weights := make(map[uint32]float32, len(other.Indices))
+	for i, index := range other.Indices {
+		weights[index] = other.Values[i]
+	}
+
+	var score float32
+	for i, index := range v.Indices {
+		score += v.Values[i] * weights[index]
+	}
+	return score
+}
+
+// Term is a word of the vocabulary with the number of chunks it occurs in
+type Term struct {
+	Id                uint32 `json:"id"`
+	DocumentFrequency int    `json:"document_frequency"`
+}
+
+// Statistics are the counts bm25 needs for a set of chunks, e.g. the chunks of a file
+type Statistics struct {
+	// Terms is the number of chunks each term occurs in
+	Terms map[string]int `json:"terms"`
+	// Documents is the number of chunks and TotalLength the sum of their lengths in terms
+	Documents   int `json:"documents"`
+	TotalLength int `json:"total_length"`
+}
+
+// Add counts the terms of the chunk
+func (s *Statistics) Add(text string) {
+	if s.Terms == nil {
+		s.Terms = map[string]int{}
+	}
+	for word, f := range TermFrequencies(text) {
+		s.Terms[word]++
+		s.TotalLength += f
+	}
+	s.Documents++
+}
+
+// Vocabulary assigns stable ids to terms and collects the statistics bm25 needs. Chunks are encoded with
+// the saturated term frequency, queries with the inverse document frequency, so their dot product is the bm25 score.
+// Encoding does not change the statistics, the ingester adds those of a file once its chunks are stored
+// and subtracts them when the file changes or is removed.
+type Vocabulary struct {
+	Terms map[string]Term `json:"terms"`
+	// Documents is the number of encoded chunks and TotalLength the sum of their lengths in terms
+	Documents   int `json:"documents"`
+	TotalLength int `json:"total_length"`
+	path        string
+	mu          sync.RWMutex
+}
+
+func NewVocabulary(path string) *Vocabulary {
+	return &Vocabulary{
+		Terms: map[string]Term{},
+		path:  path,
+	}
+}
+
+// Reset forgets all terms, e.g. for a new collection
+func (v *Vocabulary) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.Terms = map[string]Term{}
+	v.Documents = 0
+	v.TotalLength = 0
+}
+
+// LoadVocabulary reads the vocabulary at path. A missing file results in an empty vocabulary.
+func LoadVocabulary(path string) (*Vocabulary, error) {
+	vocabulary := NewVocabulary(path)
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return vocabulary, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, vocabulary); err != nil {
+		return nil, err
+	}
+	if vocabulary.Terms == nil {
+		vocabulary.Terms = map[string]Term{}
+	}
+	return vocabulary, nil
+}
+
+func (v *Vocabulary) Save() error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := v.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, v.path)
+}
+
+// EncodeDocument returns the bm25 term weights of the chunk, new terms get an id
+func (v *Vocabulary) EncodeDocument(text string) SparseVector {
+	frequencies := TermFrequencies(text)
+	length := 0
+	for _, f := range frequencies {
+		length += f
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// the chunk counts towards the average, so the first chunk of an empty vocabulary has the average length
+	averageLength := float64(v.TotalLength+length) / float64(v.Documents+1)
+
+	weights := map[uint32]float32{}
+	for word, f := range frequencies {
+		term, known := v.Terms[word]
+		if !known {
+			term.Id = uint32(len(v.Terms))
+			v.Terms[word] = term
+		}
+
+		tf := float64(f)
+		weights[term.Id] = float32(tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(length)/averageLength)))
+	}
+	return sparseVector(weights)
+}
+
+// Add counts the chunks of the statistics, e.g. of a file that was stored
+func (v *Vocabulary) Add(statistics Statistics) {
+	v.update(statistics, 1)
+}
+
+// Remove subtracts the chunks of the statistics, e.g. of a file that was changed or removed. Terms keep their ids.
+func (v *Vocabulary) Remove(statistics Statistics) {
+	v.update(statistics, -1)
+}
+
+func (v *Vocabulary) update(statistics Statistics, sign int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.Documents = max(0, v.Documents+sign*statistics.Documents)
+	v.TotalLength = max(0, v.TotalLength+sign*statistics.TotalLength)
+	for word, documents := range statistics.Terms {
+		term, known := v.Terms[word]
+		if !known {
+			term.Id = uint32(len(v.Terms))
+		}
+		term.DocumentFrequency = max(0, term.DocumentFrequency+sign*documents)
+		v.Terms[word] = term
+	}
+}
+
+// EncodeQuery returns the inverse document frequencies of the known terms of the query
+func (v *Vocabulary) EncodeQuery(text string) SparseVector {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	weights := map[uint32]float32{}
+	for word := range TermFrequencies(text) {
+		term, known := v.Terms[word]
+		if !known {
+			continue
+		}
+		df := float64(term.DocumentFrequency)
+		weights[term.Id] = float32(math.Log(1 + (float64(v.Documents)-df+0.5)/(df+0.5)))
+	}
+	return sparseVector(weights)
+}
+
+// sparseVector orders the weights by term id, qdrant does not need it but it keeps the vectors comparable
+func sparseVector(weights map[uint32]float32) SparseVector {
+	vector := SparseVector{}
+	for index := range weights {
+		vector.Indices = append(vector.Indices, index)
+	}
+	sort.Slice(vector.Indices, func(i, j int) bool { return vector.Indices[i] < vector.Indices[j] })
+	for _, index := range vector.Indices {
+		vector.Values = append(vector.Values, weights[index])
+	}
+	return vector
+}
+
+// stopWords carry no meaning for keyword matches
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "by": true, "for": true,
+	"from": true, "has": true, "in": true, "is": true, "it": true, "of": true, "on": true, "or": true, "that": true,
+	"the": true, "this": true, "to": true, "was": true, "were": true, "which": true, "with": true,
+}
+
+// TermFrequencies counts the words of the text without stop words.
+// It splits at everything but letters and digits, so identifiers like MF-2041 become mf and 2041.
+func TermFrequencies(text string) map[string]int {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	frequencies := map[string]int{}
+	for _, word := range words {
+		if stopWords[word] {
+			continue
+		}
+		frequencies[word]++
+	}
+	return frequencies
+}
@@ -0,0 +1,30 @@
+package embedding
+
+import "testing"
+
+func TestVocabularyStatistics(t *testing.T) {
+	vocabulary := NewVocabulary("")
+
+	var file Statistics
+	for _, chunk := range []string{"the mainframe migration", "mainframe costs"} {
+		vocabulary.EncodeDocument(chunk)
+		file.Add(chunk)
+	}
+	if vocabulary.Documents != 0 || vocabulary.Terms["mainframe"].DocumentFrequency != 0 {
+		t.Fatalf("encoding must not count chunks, got %d documents", vocabulary.Documents)
+	}
+	id := vocabulary.Terms["mainframe"].Id
+
+	vocabulary.Add(file)
+	if vocabulary.Documents != 2 || vocabulary.TotalLength != 4 || vocabulary.Terms["mainframe"].DocumentFrequency != 2 {
+		t.Errorf("expected 2 chunks with 4 terms, got %d chunks with %d terms and %+v", vocabulary.Documents, vocabulary.TotalLength, vocabulary.Terms["mainframe"])
+	}
+
+	vocabulary.Remove(file)
+	if vocabulary.Documents != 0 || vocabulary.TotalLength != 0 || vocabulary.Terms["mainframe"].DocumentFrequency != 0 {
+		t.Errorf("expected no chunks after the removal, got %d chunks with %d terms", vocabulary.Documents, vocabulary.TotalLength)
+	}
+	if vocabulary.Terms["mainframe"].Id != id {
+		t.Errorf("terms must keep their ids, got %d instead of %d", vocabulary.Terms["mainframe"].Id, id)
+	}
+}
@@ -0,0 +1,145 @@
+package ingest
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	docxMimeType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	// docxBody is the part of the archive with the text of the document
+	docxBody = "word/document.xml"
+)
+
+// DocxLoader reads the paragraphs of word/document.xml. Paragraphs styled as headings start a new section.
+type DocxLoader struct{}
+
+func (l *DocxLoader) Load(path string) (*Document, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close() //nolint:errcheck
+
+	body, err := archive.Open(docxBody)
+	if err != nil {
+		return nil, errors.New("not a word document, word/document.xml is missing")
+	}
+	defer body.Close() //nolint:errcheck
+
+	doc := &Document{
+		Path:  path,
+		Type:  "docx",
+		Title: docxTitle(&archive.Reader),
+	}
+	documentTitle := ""
+
+	var current Section
+	var text strings.Builder
+	flush := func() {
+		current.Text = normalizeText(text.String())
+		if current.Text != "" {
+			doc.Sections = append(doc.Sections, current)
+		}
+		text.Reset()
+	}
+
+	decoder := xml.NewDecoder(body)
+	var paragraph strings.Builder
+	style := ""
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				paragraph.Reset()
+				style = ""
+			case "pStyle":
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "val" {
+						style = strings.ToLower(strings.ReplaceAll(attr.Value, " ", ""))
+					}
+				}
+			case "t":
+				var content string
+				if err := decoder.DecodeElement(&content, &t); err != nil {
+					return nil, err
+				}
+				paragraph.WriteString(content)
+			case "tab":
+				paragraph.WriteString("\t")
+			case "br", "cr":
+				paragraph.WriteString("\n")
+			}
+		case xml.EndElement:
+			if t.Name.Local != "p" {
+				continue
+			}
+
+			content := normalizeText(paragraph.String())
+			switch {
+			case content == "":
+				continue
+			case style == "title":
+				documentTitle = content
+			case strings.HasPrefix(style, "heading"):
+				flush()
+				current = Section{Heading: content}
+				doc.Headings = append(doc.Headings, content)
+			}
+			text.WriteString(content)
+			text.WriteString("\n\n")
+		}
+	}
+	flush()
+
+	if doc.Title == "" {
+		doc.Title = documentTitle
+	}
+	if doc.Title == "" {
+		doc.Title = titleFromPath(path)
+	}
+	return doc, nil
+}
+
+// isDocx reports whether the file is a zip archive with a word document, other zip files are not loaded
+func isDocx(path string) bool {
+	archive, err := zip.OpenReader(filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	defer archive.Close() //nolint:errcheck
+
+	_, err = fs.Stat(archive, docxBody)
+	return err == nil
+}
+
+// docxTitle reads the title from the document properties, if there is one
+func docxTitle(archive *zip.Reader) string {
+	file, err := archive.Open("docProps/core.xml")
+	if err != nil {
+		return ""
+	}
+	defer file.Close() //nolint:errcheck
+
+	var properties struct {
+		Title string `xml:"title"`
+	}
+	if err := xml.NewDecoder(file).Decode(&properties); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(properties.Title)
+}
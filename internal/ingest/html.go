@@ -0,0 +1,119 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	htmlHeadings = map[atom.Atom]bool{
+		atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	}
+	// the text of these elements is never part of the content
+	htmlSkipped = map[atom.Atom]bool{
+		atom.Head: true, atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true, atom.Nav: true,
+	}
+	htmlBlocks = map[atom.Atom]bool{
+		atom.P: true, atom.Div: true, atom.Br: true, atom.Li: true, atom.Tr: true, atom.Table: true, atom.Ul: true,
+		atom.Ol: true, atom.Pre: true, atom.Blockquote: true, atom.Section: true, atom.Article: true, atom.Hr: true,
+	}
+)
+
+// HtmlLoader extracts the visible text and creates a section for every heading, e.g. for exported Confluence pages
+type HtmlLoader struct{}
+
+func (l *HtmlLoader) Load(path string) (*Document, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	root, err := html.Parse(file)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{Path: path, Type: "html"}
+	if title := findElement(root, atom.Title); title != nil {
+		doc.Title = normalizeText(textContent(title))
+	}
+
+	var current Section
+	var text strings.Builder
+	flush := func() {
+		current.Text = normalizeText(text.String())
+		if current.Text != "" {
+			doc.Sections = append(doc.Sections, current)
+		}
+		text.Reset()
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			text.WriteString(n.Data)
+			return
+		case n.Type != html.ElementNode && n.Type != html.DocumentNode:
+			return
+		case htmlSkipped[n.DataAtom]:
+			return
+		case htmlHeadings[n.DataAtom]:
+			flush()
+			heading := normalizeText(textContent(n))
+			current = Section{Heading: heading}
+			doc.Headings = append(doc.Headings, heading)
+			if doc.Title == "" && n.DataAtom == atom.H1 {
+				doc.Title = heading
+			}
+			text.WriteString(heading)
+			text.WriteString("\n\n")
+			return
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if htmlBlocks[n.DataAtom] {
+			text.WriteString("\n")
+		}
+		if n.DataAtom == atom.Td || n.DataAtom == atom.Th {
+			text.WriteString(" ")
+		}
+	}
+	walk(root)
+	flush()
+
+	if doc.Title == "" {
+		doc.Title = titleFromPath(path)
+	}
+	return doc, nil
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var text strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text.WriteString(textContent(c))
+	}
+	return text.String()
+}
@@ -0,0 +1,486 @@
+package ingest
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/koenighotze/rag-demo/config"
+	"github.com/koenighotze/rag-demo/internal/embedding"
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+)
+
+const (
+	// a partial batch is embedded after waiting this long for more chunks
+	batchTimeout = 200 * time.Millisecond
+)
+
+// fileJob follows a single file through the stages of the pipeline
+type fileJob struct {
+	path     string
+	info     fs.FileInfo
+	hash     string
+	loader   DocumentLoader
+	doc      *Document
+	pointIds []string
+	// statistics are added to the vocabulary once all chunks are stored
+	statistics embedding.Statistics
+	// tags and ingestedAt are stored with every chunk of the file
+	tags       []string
+	ingestedAt time.Time
+	// pending counts the chunks that are not stored yet
+	pending atomic.Int64
+	failed  atomic.Bool
+}
+
+type chunk struct {
+	job  *fileJob
+	item *embedding.KnowledgeItem
+}
+
+// Ingester indexes a corpus with a pipeline of load, split, embed and upsert stages connected by channels.
+// Each stage runs its own pool of workers.
+type Ingester struct {
+	store      vectordb.VectorStore
+	embedder   embedding.Embedder
+	vocabulary *embedding.Vocabulary
+	registry   *Registry
+	manifest   *Manifest
+	config     config.Ingestion
+}
+
+// NewIngester creates an ingester, the vocabulary computes the bm25 weights of the chunks and is saved with the manifest
+func NewIngester(store vectordb.VectorStore, embedder embedding.Embedder, vocabulary *embedding.Vocabulary, registry *Registry, manifest *Manifest, ingestionConfig config.Ingestion) *Ingester {
+	return &Ingester{
+		store:      store,
+		embedder:   embedder,
+		vocabulary: vocabulary,
+		registry:   registry,
+		manifest:   manifest,
+		config:     withDefaults(ingestionConfig),
+	}
+}
+
+func withDefaults(c config.Ingestion) config.Ingestion {
+	if c.LoadWorkers <= 0 {
+		c.LoadWorkers = runtime.NumCPU()
+	}
+	if c.SplitWorkers <= 0 {
+		c.SplitWorkers = runtime.NumCPU()
+	}
+	if c.EmbedWorkers <= 0 {
+		c.EmbedWorkers = 1
+	}
+	if c.UpsertWorkers <= 0 {
+		c.UpsertWorkers = 1
+	}
+	if c.EmbedBatchSize <= 0 {
+		c.EmbedBatchSize = 32
+	}
+	return c
+}
+
+// Run indexes all new and changed files below root. If ctx is cancelled, the files in flight are dropped
+// and will be picked up by the next run. Files that were completely stored are kept in the manifest.
+func (i *Ingester) Run(ctx context.Context, root string) error {
+	log.Printf("Ingesting %s with %d load, %d split, %d embed and %d upsert workers, embedding batches of %d",
+		root, i.config.LoadWorkers, i.config.SplitWorkers, i.config.EmbedWorkers, i.config.UpsertWorkers, i.config.EmbedBatchSize)
+
+	files := make(chan *fileJob)
+	loaded := make(chan *fileJob)
+	chunks := make(chan chunk)
+	batches := make(chan []chunk)
+	embedded := make(chan []chunk)
+	completed := make(chan *fileJob)
+
+	var walkErr error
+	seen := map[string]bool{}
+	go func() {
+		defer close(files)
+		walkErr = i.walk(ctx, root, seen, files)
+	}()
+
+	runWorkers(i.config.LoadWorkers, func() { i.load(ctx, files, loaded) }, func() { close(loaded) })
+
+	// completed files are reported by the split stage for empty documents and by the upsert stage
+	var reporters sync.WaitGroup
+	reporters.Add(2)
+	go func() {
+		reporters.Wait()
+		close(completed)
+	}()
+
+	runWorkers(i.config.SplitWorkers, func() { i.split(ctx, loaded, chunks, completed) }, func() {
+		close(chunks)
+		reporters.Done()
+	})
+	go func() {
+		defer close(batches)
+		i.batch(ctx, chunks, batches)
+	}()
+	runWorkers(i.config.EmbedWorkers, func() { i.embed(ctx, batches, embedded) }, func() { close(embedded) })
+	runWorkers(i.config.UpsertWorkers, func() { i.upsert(ctx, embedded, completed) }, reporters.Done)
+
+	if err := i.record(completed); err != nil {
+		return err
+	}
+
+	if walkErr != nil || ctx.Err() != nil {
+		log.Println("Ingestion did not finish, keeping the points of files that were not seen")
+		if err := i.save(); err != nil {
+			return err
+		}
+		if walkErr != nil {
+			return walkErr
+		}
+		return ctx.Err()
+	}
+
+	stale, removed := i.manifest.Prune(seen)
+	for _, statistics := range removed {
+		i.vocabulary.Remove(statistics)
+	}
+	if len(stale) > 0 {
+		log.Printf("Deleting %d points of removed files", len(stale))
+		if err := i.store.DeletePoints(stale); err != nil {
+			return err
+		}
+	}
+
+	return i.save()
+}
+
+// save stores the vocabulary first, so the manifest never lists files whose terms are unknown
+func (i *Ingester) save() error {
+	if err := i.vocabulary.Save(); err != nil {
+		return err
+	}
+	return i.manifest.Save()
+}
+
+// runWorkers starts n workers and calls done once all of them returned, usually to close their output channel
+func runWorkers(n int, worker func(), done func()) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for range n {
+		go func() {
+			defer wg.Done()
+			worker()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		done()
+	}()
+}
+
+func send[T any](ctx context.Context, out chan<- T, value T) bool {
+	select {
+	case out <- value:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (i *Ingester) walk(ctx context.Context, root string, seen map[string]bool, files chan<- *fileJob) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Println("Walking on " + path)
+
+		if !d.Type().IsRegular() {
+			log.Printf("Skip %s. Is not a regular file", path)
+
+			return nil
+		}
+
+		loader, ok := i.registry.LoaderFor(path)
+		if !ok {
+			log.Printf("Skip %s. There is no loader for this kind of file", path)
+
+			return nil
+		}
+		seen[path] = true
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		changed, hash, err := i.manifest.Changed(path, info)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			log.Printf("Skip %s. Is unchanged since the last run", path)
+			return nil
+		}
+
+		send(ctx, files, &fileJob{path: path, info: info, hash: hash, loader: loader, tags: tagsFor(root, path), ingestedAt: time.Now()})
+		return nil
+	})
+}
+
+// tagsFor derives the tags of a file from its directories below root, e.g. architecture for root/architecture/adr.md
+func tagsFor(root string, path string) []string {
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil || rel == "." {
+		return nil
+	}
+
+	var tags []string
+	for _, dir := range strings.Split(filepath.ToSlash(rel), "/") {
+		tags = append(tags, strings.ToLower(dir))
+	}
+	return tags
+}
+
+func (i *Ingester) load(ctx context.Context, files <-chan *fileJob, loaded chan<- *fileJob) {
+	for job := range files {
+		log.Printf("Loading file %s", job.path)
+		doc, err := job.loader.Load(job.path)
+		if err != nil {
+			// a single broken file should not stop the whole run
+			log.Printf("Cannot load %s. %s", job.path, err)
+			continue
+		}
+		job.doc = doc
+		send(ctx, loaded, job)
+	}
+}
+
+func (i *Ingester) split(ctx context.Context, loaded <-chan *fileJob, chunks chan<- chunk, completed chan<- *fileJob) {
+	for job := range loaded {
+		items, err := i.splitDocument(job.doc)
+		if err != nil {
+			log.Printf("Cannot split %s. %s", job.path, err)
+			continue
+		}
+
+		for _, item := range items {
+			item.Tags = job.tags
+			item.IngestedAt = job.ingestedAt
+			job.pointIds = append(job.pointIds, item.Id)
+			job.statistics.Add(item.Chunk)
+		}
+		job.pending.Store(int64(len(items)))
+
+		if len(items) == 0 {
+			send(ctx, completed, job)
+			continue
+		}
+		for _, item := range items {
+			if !send(ctx, chunks, chunk{job: job, item: item}) {
+				break
+			}
+		}
+	}
+}
+
+// sectionStart marks where a section begins within the text of a document
+type sectionStart struct {
+	offset int
+	page   int
+}
+
+// splitDocument splits the text of the whole document, so chunk boundaries are only chosen by the chunker
+func (i *Ingester) splitDocument(doc *Document) ([]*embedding.KnowledgeItem, error) {
+	log.Printf("Processing text of %s", doc.Path)
+
+	text := doc.Text()
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	// Document.Text separates the sections with a blank line
+	var sections []sectionStart
+	offset := 0
+	for _, section := range doc.Sections {
+		sections = append(sections, sectionStart{offset: offset, page: section.Page})
+		offset += len(section.Text) + len("\n\n")
+	}
+
+	texts, err := i.embedder.SplitText(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []*embedding.KnowledgeItem
+	cursor := 0
+	// the character offset of the cursor, the offsets of the chunks count characters and not bytes
+	cursorChars := 0
+	for _, chunkText := range texts {
+		start, end := locateChunk(text, chunkText, cursor)
+		startChars := cursorChars + utf8.RuneCountInString(text[cursor:start])
+
+		items = append(items, &embedding.KnowledgeItem{
+			Id:             embedding.ChunkId(doc.Path, len(items), chunkText),
+			SourceDocument: doc.Path,
+			Title:          doc.Title,
+			StartPage:      pageAt(sections, start),
+			EndPage:        pageAt(sections, max(start, end-1)),
+			ChunkIndex:     len(items),
+			StartOffset:    startChars,
+			EndOffset:      startChars + utf8.RuneCountInString(text[start:end]),
+			Chunk:          chunkText,
+			DocumentType:   doc.Type,
+			Sparse:         i.vocabulary.EncodeDocument(chunkText),
+		})
+
+		// chunks may overlap, so the next one can start right after the start of this one
+		if start < len(text) {
+			_, width := utf8.DecodeRuneInString(text[start:])
+			cursor, cursorChars = start+width, startChars+1
+		}
+	}
+	return items, nil
+}
+
+// locateChunk finds the byte range of the chunk in text, starting the search at cursor. The markdown chunker prepends
+// the heading hierarchy to every chunk, so the body below the headings is searched if the whole chunk is not found,
+// and its first line if the body is not found either. Splitters may change whitespace, if nothing is found
+// the chunk is assumed to start at the cursor.
+func locateChunk(text string, chunk string, cursor int) (start int, end int) {
+	if i := strings.Index(text[cursor:], chunk); i >= 0 {
+		return cursor + i, cursor + i + len(chunk)
+	}
+
+	body := chunkBody(chunk)
+	firstLine, _, _ := strings.Cut(body, "\n")
+	for _, part := range []string{body, firstLine} {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		if i := strings.Index(text[cursor:], part); i >= 0 {
+			return cursor + i, min(cursor+i+len(body), len(text))
+		}
+	}
+	return cursor, min(cursor+len(chunk), len(text))
+}
+
+// chunkBody strips the leading markdown headings of the chunk. A chunk of headings only is located by its last heading.
+func chunkBody(chunk string) string {
+	lines := strings.Split(chunk, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			return strings.Join(lines[i:], "\n")
+		}
+	}
+	return lines[len(lines)-1]
+}
+
+// pageAt returns the page of the section that contains the byte offset
+func pageAt(sections []sectionStart, offset int) int {
+	page := 0
+	for _, s := range sections {
+		if s.offset > offset {
+			break
+		}
+		page = s.page
+	}
+	return page
+}
+
+// batch collects chunks of any file into batches for the embedding model
+func (i *Ingester) batch(ctx context.Context, chunks <-chan chunk, batches chan<- []chunk) {
+	var current []chunk
+	flush := func() {
+		if len(current) > 0 {
+			send(ctx, batches, current)
+			current = nil
+		}
+	}
+
+	for {
+		select {
+		case c, ok := <-chunks:
+			if !ok {
+				flush()
+				return
+			}
+			current = append(current, c)
+			if len(current) >= i.config.EmbedBatchSize {
+				flush()
+			}
+		case <-time.After(batchTimeout):
+			flush()
+		}
+	}
+}
+
+func (i *Ingester) embed(ctx context.Context, batches <-chan []chunk, embedded chan<- []chunk) {
+	for batch := range batches {
+		var items []*embedding.KnowledgeItem
+		for _, c := range batch {
+			items = append(items, c.item)
+		}
+
+		if err := i.embedder.EmbedItems(ctx, items); err != nil {
+			log.Printf("Cannot embed batch of %d chunks. %s", len(batch), err)
+			for _, c := range batch {
+				c.job.failed.Store(true)
+			}
+			continue
+		}
+		log.Printf("Generated %d embeddings", len(items))
+		send(ctx, embedded, batch)
+	}
+}
+
+func (i *Ingester) upsert(ctx context.Context, embedded <-chan []chunk, completed chan<- *fileJob) {
+	for batch := range embedded {
+		var items []*embedding.KnowledgeItem
+		for _, c := range batch {
+			items = append(items, c.item)
+		}
+
+		if err := i.store.AddPointsToCollection(items); err != nil {
+			log.Printf("Cannot store batch of %d chunks. %s", len(batch), err)
+			for _, c := range batch {
+				c.job.failed.Store(true)
+			}
+		}
+
+		for _, c := range batch {
+			if c.job.pending.Add(-1) == 0 {
+				send(ctx, completed, c.job)
+			}
+		}
+	}
+}
+
+// record updates the manifest and the vocabulary for every completely stored file and deletes the points
+// the file no longer has
+func (i *Ingester) record(completed <-chan *fileJob) error {
+	var err error
+	for job := range completed {
+		if job.failed.Load() {
+			// not recording the file makes the next run retry it
+			log.Printf("Not all chunks of %s could be stored, will retry on the next run", job.path)
+			continue
+		}
+
+		log.Printf("Indexed %s with %d chunks", job.path, len(job.pointIds))
+		stale, previous := i.manifest.Record(job.path, job.info, job.hash, job.pointIds, job.statistics)
+		i.vocabulary.Remove(previous)
+		i.vocabulary.Add(job.statistics)
+		if len(stale) == 0 || err != nil {
+			continue
+		}
+		log.Printf("Deleting %d outdated points of %s", len(stale), job.path)
+		err = i.store.DeletePoints(stale)
+	}
+	return err
+}
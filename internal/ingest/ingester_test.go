@@ -0,0 +1,84 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/koenighotze/rag-demo/config"
+	"github.com/koenighotze/rag-demo/internal/embedding"
+	"github.com/koenighotze/rag-demo/internal/provider"
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+)
+
+func TestLocateChunk(t *testing.T) {
+	text := "# Mainframe\n\nThe program moves the mainframe to the cloud.\n\n## Goals\n\nReduce the cost by half.\nRetire the batch jobs.\n\nReduce the cost by half."
+
+	tests := []struct {
+		name   string
+		chunk  string
+		cursor int
+		want   string
+	}{
+		{"verbatim chunk", "The program moves the mainframe to the cloud.", 0, "The program moves the mainframe to the cloud."},
+		{"verbatim chunk after the cursor", "Reduce the cost by half.", 80, "Reduce the cost by half."},
+		{"body below the heading hierarchy", "# Mainframe\n## Goals\nReduce the cost by half.\nRetire the batch jobs.", 0,
+			"Reduce the cost by half.\nRetire the batch jobs."},
+		// the range has the length of the reformatted body
+		{"first line of a reformatted body", "# Mainframe\n## Goals\nReduce the cost by half.\nRetire  the batch jobs.", 0,
+			"Reduce the cost by half.\nRetire the batch jobs.\n"},
+		{"headings only", "# Mainframe\n## Goals", 0, "## Goals"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := locateChunk(text, tt.chunk, tt.cursor)
+			if got := text[start:end]; got != tt.want {
+				t.Errorf("located %q, expected %q", got, tt.want)
+			}
+		})
+	}
+
+	start, end := locateChunk(text, "not part of the text", 10)
+	if start != 10 || end != 10+len("not part of the text") {
+		t.Errorf("expected the range at the cursor for an unknown chunk, got %d-%d", start, end)
+	}
+}
+
+func TestSplitMarkdownWithHeadings(t *testing.T) {
+	embedder, err := embedding.NewEmbedder(config.Embedding{ModelName: "fake", ChunkStrategy: embedding.ChunkMarkdown, ChunkSize: 80}, provider.NewFakeModel(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingester := NewIngester(vectordb.NewInMemoryVectorStore(nil), embedder, embedding.NewVocabulary(""), DefaultRegistry(), NewManifest(""), config.Ingestion{})
+
+	doc := &Document{
+		Path: "corpus/mainframe.md",
+		Type: "markdown",
+		Sections: []Section{
+			{Heading: "Mainframe", Text: "# Mainframe\n\nThe program moves the mainframe to the cloud."},
+			{Heading: "Goals", Text: "## Goals\n\nReduce the cost of operations by half.\nRetire the COBOL batch jobs."},
+			{Heading: "Risks", Text: "### Risks\n\nThe documentation had to be interpreted by SMEs."},
+		},
+	}
+	items, err := ingester.splitDocument(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := []string{
+		"The program moves the mainframe to the cloud.",
+		"Reduce the cost of operations by half.\nRetire the COBOL batch jobs.",
+		"The documentation had to be interpreted by SMEs.",
+	}
+	if len(items) != len(bodies) {
+		t.Fatalf("expected %d chunks, got %d", len(bodies), len(items))
+	}
+	runes := []rune(doc.Text())
+	for i, item := range items {
+		if item.ChunkIndex != i {
+			t.Errorf("chunk %d has index %d", i, item.ChunkIndex)
+		}
+		if got := string(runes[item.StartOffset:item.EndOffset]); got != bodies[i] {
+			t.Errorf("offsets of chunk %d point at %q, expected %q", i, got, bodies[i])
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package ingest
+
+import (
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Section is a part of a document, a page for paged formats or the text below a heading otherwise
+type Section struct {
+	// Page starts with 1, it is 0 for formats without pages
+	Page    int
+	Heading string
+	Text    string
+}
+
+type Document struct {
+	Path string
+	// Type is the format of the file, e.g. pdf or markdown
+	Type     string
+	Title    string
+	Headings []string
+	Sections []Section
+}
+
+func (d *Document) Text() string {
+	var texts []string
+	for _, s := range d.Sections {
+		texts = append(texts, s.Text)
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+type DocumentLoader interface {
+	Load(path string) (*Document, error)
+}
+
+// Registry picks the loader for a file by its extension and falls back to sniffing the MIME type
+type Registry struct {
+	byExtension map[string]DocumentLoader
+	byMimeType  map[string]DocumentLoader
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		byExtension: map[string]DocumentLoader{},
+		byMimeType:  map[string]DocumentLoader{},
+	}
+}
+
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(&PdfLoader{}, []string{"application/pdf"}, ".pdf")
+	r.Register(&MarkdownLoader{}, []string{"text/markdown"}, ".md", ".markdown")
+	// binary files with a few bytes of text look like text/plain to sniffing, so text files need an extension
+	r.Register(&TextLoader{}, nil, ".txt", ".text")
+	r.Register(&HtmlLoader{}, []string{"text/html"}, ".html", ".htm")
+	r.Register(&DocxLoader{}, []string{docxMimeType}, ".docx")
+	return r
+}
+
+func (r *Registry) Register(loader DocumentLoader, mimeTypes []string, extensions ...string) {
+	for _, ext := range extensions {
+		r.byExtension[strings.ToLower(ext)] = loader
+	}
+	for _, mimeType := range mimeTypes {
+		r.byMimeType[mimeType] = loader
+	}
+}
+
+func (r *Registry) LoaderFor(path string) (DocumentLoader, bool) {
+	if loader, ok := r.byExtension[strings.ToLower(filepath.Ext(path))]; ok {
+		return loader, true
+	}
+
+	mimeType, err := sniffMimeType(path)
+	if err != nil {
+		log.Printf("Cannot detect the type of %s. %s", path, err)
+		return nil, false
+	}
+	loader, ok := r.byMimeType[mimeType]
+	if !ok {
+		log.Printf("Unsupported file type %s of %s", mimeType, path)
+	}
+	return loader, ok
+}
+
+func sniffMimeType(path string) (string, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close() //nolint:errcheck
+
+	// DetectContentType considers at most 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "", err
+	}
+	// docx files are zip archives with the document in word/document.xml
+	if mimeType == "application/zip" && isDocx(path) {
+		return docxMimeType, nil
+	}
+	return mimeType, nil
+}
+
+var (
+	horizontalSpace = regexp.MustCompile(`[ \t\f\v\x{00a0}]+`)
+	blankLines      = regexp.MustCompile(`\n{3,}`)
+)
+
+// normalizeText unifies line endings, collapses runs of whitespace and trims every line
+func normalizeText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = horizontalSpace.ReplaceAllString(text, " ")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = strings.Join(lines, "\n")
+
+	return strings.TrimSpace(blankLines.ReplaceAllString(text, "\n\n"))
+}
+
+func titleFromPath(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
@@ -0,0 +1,77 @@
+package ingest
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeZip(t *testing.T, path string, names ...string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	archive := zip.NewWriter(file)
+	for _, name := range names {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("<xml/>")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoaderFor(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	wordDocument := filepath.Join(dir, "report")
+	writeZip(t, wordDocument, "[Content_Types].xml", docxBody)
+	archive := filepath.Join(dir, "archive")
+	writeZip(t, archive, "notes.txt")
+
+	tests := []struct {
+		name string
+		path string
+		want DocumentLoader
+	}{
+		{"markdown by extension", write("adr.MD", "# ADR"), &MarkdownLoader{}},
+		{"text by extension", write("notes.txt", "plain"), &TextLoader{}},
+		{"html without extension", write("page", "<!DOCTYPE html><html><body>hi</body></html>"), &HtmlLoader{}},
+		{"pdf without extension", write("scan", "%PDF-1.7\n"), &PdfLoader{}},
+		{"word document without extension", wordDocument, &DocxLoader{}},
+		{"other zip archives are skipped", archive, nil},
+		{"text without extension is skipped", write("LICENSE", "plain text"), nil},
+		{"binary files are skipped", write("blob", "\x00\x01\x02\x03binary"), nil},
+		{"unknown extension is sniffed", write("image.png", "\x89PNG\r\n\x1a\n"), nil},
+	}
+
+	registry := DefaultRegistry()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader, ok := registry.LoaderFor(tt.path)
+			if ok != (tt.want != nil) {
+				t.Fatalf("expected a loader %t, got %T", tt.want != nil, loader)
+			}
+			if ok && reflect.TypeOf(loader) != reflect.TypeOf(tt.want) {
+				t.Errorf("expected %T, got %T", tt.want, loader)
+			}
+		})
+	}
+}
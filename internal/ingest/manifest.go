@@ -0,0 +1,161 @@
+package ingest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/koenighotze/rag-demo/internal/embedding"
+)
+
+// FileEntry records the state of a file at the time it was indexed and the points created from it
+type FileEntry struct {
+	ModTime  time.Time `json:"mod_time"`
+	Hash     string    `json:"hash"`
+	PointIds []string  `json:"point_ids"`
+	// Statistics are the bm25 counts of the chunks, they are subtracted from the vocabulary if the file changes.
+	// Files indexed before the counts were recorded have none, a rebuild recomputes the vocabulary.
+	Statistics embedding.Statistics `json:"statistics"`
+}
+
+// Manifest keeps track of the indexed files, so a re-run only embeds new or changed files
+type Manifest struct {
+	Files map[string]FileEntry `json:"files"`
+	path  string
+	mu    sync.Mutex
+}
+
+func NewManifest(path string) *Manifest {
+	return &Manifest{
+		Files: map[string]FileEntry{},
+		path:  path,
+	}
+}
+
+// LoadManifest reads the manifest at path. A missing file results in an empty manifest.
+func LoadManifest(path string) (*Manifest, error) {
+	manifest := NewManifest(path)
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Files == nil {
+		manifest.Files = map[string]FileEntry{}
+	}
+	return manifest, nil
+}
+
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// Changed reports whether the file needs to be (re-)indexed. The hash is only computed if the modification time differs.
+// Unchanged files with a new modification time are updated in place.
+func (m *Manifest) Changed(path string, info fs.FileInfo) (changed bool, hash string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, known := m.Files[path]
+	if known && entry.ModTime.Equal(info.ModTime()) {
+		return false, entry.Hash, nil
+	}
+
+	hash, err = HashFile(path)
+	if err != nil {
+		return false, "", err
+	}
+
+	if known && entry.Hash == hash {
+		entry.ModTime = info.ModTime()
+		m.Files[path] = entry
+		return false, hash, nil
+	}
+	return true, hash, nil
+}
+
+// Record stores the new state of the file. It returns the ids of points that are no longer part of it
+// and the statistics of the previous state.
+func (m *Manifest) Record(path string, info fs.FileInfo, hash string, pointIds []string, statistics embedding.Statistics) (stale []string, previous embedding.Statistics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := map[string]bool{}
+	for _, id := range pointIds {
+		current[id] = true
+	}
+	entry := m.Files[path]
+	for _, id := range entry.PointIds {
+		if !current[id] {
+			stale = append(stale, id)
+		}
+	}
+
+	m.Files[path] = FileEntry{
+		ModTime:    info.ModTime(),
+		Hash:       hash,
+		PointIds:   pointIds,
+		Statistics: statistics,
+	}
+	return stale, entry.Statistics
+}
+
+// Prune forgets all files that were not seen and returns the ids of their points with their statistics
+func (m *Manifest) Prune(seen map[string]bool) (stale []string, removed []embedding.Statistics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for path, entry := range m.Files {
+		if seen[path] {
+			continue
+		}
+		stale = append(stale, entry.PointIds...)
+		removed = append(removed, entry.Statistics)
+		delete(m.Files, path)
+	}
+	return stale, removed
+}
+
+func HashFile(path string) (string, error) {
+	file, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close() //nolint:errcheck
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
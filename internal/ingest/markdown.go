@@ -0,0 +1,58 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var markdownHeading = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+
+// MarkdownLoader creates a section for every heading. The markdown itself is kept, headings included.
+type MarkdownLoader struct{}
+
+func (l *MarkdownLoader) Load(path string) (*Document, error) {
+	b, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{Path: path, Type: "markdown"}
+
+	var current Section
+	var text strings.Builder
+	flush := func() {
+		current.Text = normalizeText(text.String())
+		if current.Text != "" {
+			doc.Sections = append(doc.Sections, current)
+		}
+		text.Reset()
+	}
+
+	inCodeBlock := false
+	for _, line := range strings.Split(strings.ReplaceAll(string(b), "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCodeBlock = !inCodeBlock
+		}
+
+		if match := markdownHeading.FindStringSubmatch(line); match != nil && !inCodeBlock {
+			flush()
+			heading := match[2]
+			current = Section{Heading: heading}
+			doc.Headings = append(doc.Headings, heading)
+			if doc.Title == "" && len(match[1]) == 1 {
+				doc.Title = heading
+			}
+		}
+
+		text.WriteString(line)
+		text.WriteString("\n")
+	}
+	flush()
+
+	if doc.Title == "" {
+		doc.Title = titleFromPath(path)
+	}
+	return doc, nil
+}
@@ -0,0 +1,46 @@
+package ingest
+
+import (
+	"log"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+type PdfLoader struct{}
+
+func (l *PdfLoader) Load(path string) (*Document, error) {
+	file, reader, err := pdf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	doc := &Document{
+		Path:  path,
+		Type:  "pdf",
+		Title: strings.TrimSpace(reader.Trailer().Key("Info").Key("Title").Text()),
+	}
+	if doc.Title == "" {
+		doc.Title = titleFromPath(path)
+	}
+
+	// pdf pages are numbered starting with 1
+	for pageNumber := 1; pageNumber <= reader.NumPage(); pageNumber++ {
+		log.Printf("Working on page %d", pageNumber)
+
+		page := reader.Page(pageNumber)
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			log.Printf("Could not get text from page %d because of %s", pageNumber, err)
+			continue
+		}
+
+		doc.Sections = append(doc.Sections, Section{
+			Page: pageNumber,
+			Text: normalizeText(text),
+		})
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,24 @@
+package ingest
+
+import (
+	"os"
+	"path/filepath"
+)
+
+type TextLoader struct{}
+
+func (l *TextLoader) Load(path string) (*Document, error) {
+	b, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Document{
+		Path:  path,
+		Type:  "text",
+		Title: titleFromPath(path),
+		Sections: []Section{
+			{Text: normalizeText(string(b))},
+		},
+	}, nil
+}
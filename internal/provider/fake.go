@@ -0,0 +1,93 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+
+	"github.com/koenighotze/rag-demo/internal/embedding"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// fakeDimension is the size of the fake embeddings
+const fakeDimension = 64
+
+// FakeModel answers with scripted responses in turn and embeds texts by hashing their terms.
+// It needs no server, so the pipeline can run in tests.
+type FakeModel struct {
+	mu        sync.Mutex
+	responses []string
+	next      int
+}
+
+func NewFakeModel(responses []string) *FakeModel {
+	return &FakeModel{responses: responses}
+}
+
+func (f *FakeModel) response() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.responses) == 0 {
+		return "", errors.New("the fake model has no responses")
+	}
+	response := f.responses[f.next%len(f.responses)]
+	f.next++
+	return response, nil
+}
+
+// GenerateContent returns the next response, it is streamed as a single chunk if a streaming function is set
+func (f *FakeModel) GenerateContent(ctx context.Context, _ []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	response, err := f.response()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := llms.CallOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+	if opts.StreamingFunc != nil {
+		if err := opts.StreamingFunc(ctx, []byte(response)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &llms.ContentResponse{
+		Choices: []*llms.ContentChoice{{Content: response}},
+	}, nil
+}
+
+func (f *FakeModel) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, f, prompt, options...)
+}
+
+// CreateEmbedding hashes the terms of each text into a normalized vector, texts sharing terms are similar
+func (f *FakeModel) CreateEmbedding(_ context.Context, texts []string) ([][]float32, error) {
+	var embeds [][]float32
+	for _, text := range texts {
+		vector := make([]float32, fakeDimension)
+		for term, count := range embedding.TermFrequencies(text) {
+			h := fnv.New32a()
+			h.Write([]byte(term))
+			vector[h.Sum32()%fakeDimension] += float32(count)
+		}
+
+		var norm float64
+		for _, v := range vector {
+			norm += float64(v * v)
+		}
+		if norm == 0 {
+			// cosine similarity is undefined for the zero vector
+			vector[0] = 1
+			norm = 1
+		}
+		for i := range vector {
+			vector[i] /= float32(math.Sqrt(norm))
+		}
+		embeds = append(embeds, vector)
+	}
+	return embeds, nil
+}
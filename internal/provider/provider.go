@@ -0,0 +1,74 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/koenighotze/rag-demo/config"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+// types of config.Provider
+const (
+	Ollama = "ollama"
+	OpenAi = "openai"
+	Fake   = "fake"
+)
+
+// noApiKey is sent to openai compatible servers without authentication, the client refuses an empty key
+const noApiKey = "none"
+
+// NewModel connects to the model of the provider for text generation
+func NewModel(provider config.Provider, model string) (llms.Model, error) {
+	switch provider.Type {
+	case "", Ollama:
+		return newOllama(provider, model)
+	case OpenAi:
+		return newOpenAi(provider, openai.WithModel(model))
+	case Fake:
+		return NewFakeModel(provider.Responses), nil
+	default:
+		return nil, fmt.Errorf("unknown provider type %q", provider.Type)
+	}
+}
+
+// NewEmbedderClient connects to the model of the provider for embeddings
+func NewEmbedderClient(provider config.Provider, model string) (embeddings.EmbedderClient, error) {
+	switch provider.Type {
+	case "", Ollama:
+		return newOllama(provider, model)
+	case OpenAi:
+		return newOpenAi(provider, openai.WithEmbeddingModel(model))
+	case Fake:
+		return NewFakeModel(provider.Responses), nil
+	default:
+		return nil, fmt.Errorf("unknown provider type %q", provider.Type)
+	}
+}
+
+func newOllama(provider config.Provider, model string) (*ollama.LLM, error) {
+	options := []ollama.Option{ollama.WithModel(model)}
+	if provider.BaseUrl != "" {
+		options = append(options, ollama.WithServerURL(provider.BaseUrl))
+	}
+	return ollama.New(options...)
+}
+
+func newOpenAi(provider config.Provider, model openai.Option) (*openai.LLM, error) {
+	token := noApiKey
+	if provider.ApiKeyEnv != "" {
+		token = os.Getenv(provider.ApiKeyEnv)
+		if token == "" {
+			return nil, fmt.Errorf("the api key environment variable %s is not set", provider.ApiKeyEnv)
+		}
+	}
+
+	options := []openai.Option{model, openai.WithToken(token)}
+	if provider.BaseUrl != "" {
+		options = append(options, openai.WithBaseURL(provider.BaseUrl))
+	}
+	return openai.New(options...)
+}
@@ -0,0 +1,72 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+)
+
+const snippetLength = 200
+
+type Source struct {
+	// Index is the number used for the inline citation marker, e.g. [1]
+	Index int    `json:"index"`
+	Path  string `json:"path"`
+	Title string `json:"title"`
+	// Page is the page the chunk starts on, EndPage the one it ends on
+	Page        int    `json:"page"`
+	EndPage     int    `json:"end_page"`
+	ChunkId     string `json:"chunk_id"`
+	ChunkIndex  int    `json:"chunk_index"`
+	StartOffset int    `json:"start_offset"`
+	EndOffset   int    `json:"end_offset"`
+	// Score is the similarity to the query, FusedScore the rank fusion score of hybrid and multi-query searches
+	Score      float32 `json:"score"`
+	FusedScore float32 `json:"fused_score,omitempty"`
+	Snippet    string  `json:"snippet"`
+}
+
+type Answer struct {
+	Answer  string   `json:"answer"`
+	Sources []Source `json:"sources"`
+	Debug   *Debug   `json:"debug,omitempty"`
+}
+
+func sourcesFromSearchResults(results []*vectordb.SearchResult) []Source {
+	sources := []Source{}
+	for i, r := range results {
+		sources = append(sources, Source{
+			Index:       i + 1,
+			Path:        r.Item.SourceDocument,
+			Title:       r.Item.Title,
+			Page:        r.Item.StartPage,
+			EndPage:     r.Item.EndPage,
+			ChunkId:     r.Id,
+			ChunkIndex:  r.Item.ChunkIndex,
+			StartOffset: r.Item.StartOffset,
+			EndOffset:   r.Item.EndOffset,
+			Score:       r.Score,
+			FusedScore:  r.FusedScore,
+			Snippet:     snippet(r.Item.Chunk),
+		})
+	}
+	return sources
+}
+
+// pages describes where the source is found, e.g. "page 3" or "pages 3-4"
+func (s Source) pages() string {
+	if s.EndPage > s.Page {
+		return fmt.Sprintf("pages %d-%d", s.Page, s.EndPage)
+	}
+	return fmt.Sprintf("page %d", s.Page)
+}
+
+func snippet(chunk string) string {
+	text := strings.Join(strings.Fields(chunk), " ")
+	runes := []rune(text)
+	if len(runes) <= snippetLength {
+		return text
+	}
+	return string(runes[:snippetLength]) + "…"
+}
@@ -0,0 +1,226 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/koenighotze/rag-demo/config"
+	"github.com/koenighotze/rag-demo/internal/session"
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+	"github.com/tmc/langchaingo/llms"
+)
+
+const chatSystemPrompt = `You are a helpful assistant in a conversation about the documents of the user.
+Use the earlier conversation to understand follow-up questions, e.g. what "it" refers to.`
+
+const summaryPrompt = `Summarize the conversation below for yourself, so you can continue it later.
+Keep names, numbers and open questions, leave out pleasantries. Answer with the summary only.
+
+Earlier summary:
+%s
+
+Conversation:
+%s`
+
+// ChatRequest is the next message of a session, a new session is started if SessionId is empty
+type ChatRequest struct {
+	SessionId string `json:"session_id"`
+	Message   string `json:"message"`
+	RetrievalOptions
+}
+
+type ChatAnswer struct {
+	SessionId string `json:"session_id"`
+	Answer
+}
+
+// Conversations answers the messages of chat sessions with the history of the session
+type Conversations struct {
+	service *Service
+	// condenseLlm rewrites follow-up questions, it is nil if they are used as they are
+	condenseLlm llms.Model
+	sessions    session.Store
+	config      config.Chat
+	// locks serializes the messages of a session, so no turn is lost. A lock only exists while a message of its
+	// session is answered or waiting.
+	locksMu sync.Mutex
+	locks   map[string]*sessionLock
+}
+
+type sessionLock struct {
+	mu sync.Mutex
+	// refs counts the holder and the waiters, the lock is dropped when it reaches 0
+	refs int
+}
+
+func NewConversations(service *Service, condenseLlm llms.Model, sessions session.Store, chatConfig config.Chat) *Conversations {
+	return &Conversations{
+		service:     service,
+		condenseLlm: condenseLlm,
+		sessions:    sessions,
+		config:      chatConfig,
+		locks:       map[string]*sessionLock{},
+	}
+}
+
+func (c *Conversations) lock(id string) func() {
+	c.locksMu.Lock()
+	l, ok := c.locks[id]
+	if !ok {
+		l = &sessionLock{}
+		c.locks[id] = l
+	}
+	l.refs++
+	c.locksMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		c.locksMu.Lock()
+		defer c.locksMu.Unlock()
+		l.refs--
+		if l.refs == 0 {
+			delete(c.locks, id)
+		}
+	}
+}
+
+// Chat answers the message with context from the store. The session only records turns that were answered.
+func (c *Conversations) Chat(ctx context.Context, store vectordb.VectorStore, request ChatRequest) (*ChatAnswer, error) {
+	if request.SessionId == "" {
+		request.SessionId = session.NewId()
+	}
+	log.Printf("Chatting in session %s: %s", request.SessionId, request.Message)
+
+	unlock := c.lock(request.SessionId)
+	defer unlock()
+
+	conversation, err := c.sessions.Load(request.SessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	s := c.service
+	stages := []Stage{&InputGuardrailStage{Guardrail: s.inputGuardrail()}}
+	if c.condenseLlm != nil {
+		stages = append(stages, &CondenseStage{Llm: c.condenseLlm, Temperature: c.config.CondenseTemperature, Session: conversation})
+	}
+	stages = append(stages, s.retrievalStages(store, request.RetrievalOptions)...)
+	stages = append(stages,
+		&ChatGenerationStage{Llm: s.llm, Temperature: s.config.MainTemperature, Session: conversation},
+		&OutputGuardrailStage{Guardrail: s.outputGuardrail()},
+	)
+	exchange, err := NewPipeline(stages...).Run(ctx, request.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	conversation.Append(session.RoleUser, request.Message)
+	conversation.Append(session.RoleAssistant, exchange.Answer)
+	if err := c.compact(ctx, conversation); err != nil {
+		// the history is only longer than it should be, the answer is still fine
+		log.Printf("Cannot summarize the history of session %s. %s", conversation.Id, err)
+	}
+	if err := c.sessions.Save(conversation); err != nil {
+		return nil, err
+	}
+
+	return &ChatAnswer{
+		SessionId: conversation.Id,
+		Answer: Answer{
+			Answer:  exchange.Answer,
+			Sources: exchange.Sources,
+			Debug:   debug(exchange, request.RetrievalOptions),
+		},
+	}, nil
+}
+
+func (c *Conversations) Delete(id string) error {
+	unlock := c.lock(id)
+	defer unlock()
+
+	return c.sessions.Delete(id)
+}
+
+// compact folds all but the most recent messages into the summary once the history exceeds its token budget
+func (c *Conversations) compact(ctx context.Context, conversation *session.Session) error {
+	if c.config.HistoryTokenBudget <= 0 {
+		return nil
+	}
+
+	tokens := estimateTokens(conversation.Summary)
+	for _, m := range conversation.Messages {
+		tokens += estimateTokens(m.Content)
+	}
+	if tokens <= c.config.HistoryTokenBudget {
+		return nil
+	}
+
+	keep := min(c.config.KeepMessages, len(conversation.Messages))
+	old := conversation.Messages[:len(conversation.Messages)-keep]
+	if len(old) == 0 {
+		return nil
+	}
+	log.Printf("The history of session %s has %d tokens, summarizing %d messages", conversation.Id, tokens, len(old))
+
+	completion, err := chatWithLLM(ctx, c.service.llm, []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeHuman, fmt.Sprintf(summaryPrompt, conversation.Summary, transcript(old))),
+	}, PromptConfig{temperature: 0})
+	if err != nil {
+		return err
+	}
+
+	conversation.Summary = cleanupAnswer(completion)
+	conversation.Messages = conversation.Messages[len(old):]
+	return nil
+}
+
+func transcript(messages []session.Message) string {
+	var lines []string
+	for _, m := range messages {
+		lines = append(lines, fmt.Sprintf("%s: %s", m.Role, m.Content))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ChatGenerationStage sends the history of the session as chat messages followed by the prompt
+type ChatGenerationStage struct {
+	Llm         llms.Model
+	Temperature float64
+	Session     *session.Session
+}
+
+func (s *ChatGenerationStage) Name() string { return "chat-generation" }
+
+func (s *ChatGenerationStage) Run(ctx context.Context, exchange *Exchange) error {
+	augment(exchange)
+
+	completion, err := chatWithLLM(ctx, s.Llm, chatMessages(s.Session, exchange.Prompt), PromptConfig{temperature: s.Temperature})
+	if err != nil {
+		return err
+	}
+	exchange.Completion = completion
+	return nil
+}
+
+// chatMessages only contains the plain messages of earlier turns, the retrieved context is part of the latest prompt only
+func chatMessages(conversation *session.Session, prompt string) []llms.MessageContent {
+	system := chatSystemPrompt
+	if conversation.Summary != "" {
+		system += "\n\nSummary of the earlier conversation:\n" + conversation.Summary
+	}
+
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeSystem, system)}
+	for _, m := range conversation.Messages {
+		messageType := llms.ChatMessageTypeHuman
+		if m.Role == session.RoleAssistant {
+			messageType = llms.ChatMessageTypeAI
+		}
+		messages = append(messages, llms.TextParts(messageType, m.Content))
+	}
+	return append(messages, llms.TextParts(llms.ChatMessageTypeHuman, prompt))
+}
@@ -0,0 +1,54 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/koenighotze/rag-demo/internal/session"
+	"github.com/tmc/langchaingo/llms"
+)
+
+const condensePrompt = `Rewrite the latest question of the conversation below into a standalone search query.
+Replace pronouns and references like "it" or "that project" with what they refer to in the conversation.
+Keep the language of the question. Answer with the search query only.
+
+Summary of the earlier conversation:
+%s
+
+Conversation:
+%s
+
+Latest question: %s`
+
+// CondenseStage rewrites a follow-up question into a standalone query for the retrieval.
+// The first question of a session is used as it is.
+type CondenseStage struct {
+	Llm         llms.Model
+	Temperature float64
+	Session     *session.Session
+}
+
+func (s *CondenseStage) Name() string { return "condense" }
+
+func (s *CondenseStage) Run(ctx context.Context, exchange *Exchange) error {
+	if len(s.Session.Messages) == 0 && s.Session.Summary == "" {
+		return nil
+	}
+
+	prompt := fmt.Sprintf(condensePrompt, s.Session.Summary, transcript(s.Session.Messages), exchange.Query)
+	completion, err := sendToLLM(ctx, s.Llm, prompt, PromptConfig{temperature: s.Temperature})
+	if err != nil {
+		return err
+	}
+
+	condensed := strings.Trim(cleanupAnswer(completion), "\"' \n")
+	if condensed == "" {
+		log.Printf("The condensed query for %q is empty, retrieving for the original query", exchange.Query)
+		return nil
+	}
+	log.Printf("Condensed query %q into %q", exchange.Query, condensed)
+	exchange.SearchQuery = condensed
+	return nil
+}
@@ -0,0 +1,85 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// modes of config.Expansion
+const (
+	ExpansionNone       = "none"
+	ExpansionHyde       = "hyde"
+	ExpansionMultiQuery = "multi_query"
+)
+
+const hydePrompt = `Write a short passage that answers the question below, as it could appear in a document.
+Make up plausible details if you do not know the answer. Answer with the passage only.
+
+Question: %s`
+
+const multiQueryPrompt = `Write %d different phrasings of the search query below.
+Use other words and synonyms, but keep the meaning and the language of the query.
+Answer with one phrasing per line and nothing else.
+
+Query: %s`
+
+// listMarker matches the numbering or bullet a model puts in front of a line, e.g. "1." or "-"
+var listMarker = regexp.MustCompile(`^\s*(\d+[.)]|[-*•])\s*`)
+
+// ExpansionStage lets the model write a hypothetical answer (hyde) to embed instead of the query,
+// or paraphrases of the query (multi_query) to search in addition to it
+type ExpansionStage struct {
+	Llm         llms.Model
+	Mode        string
+	Queries     int
+	Temperature float64
+}
+
+func (s *ExpansionStage) Name() string { return "expansion" }
+
+func (s *ExpansionStage) Run(ctx context.Context, exchange *Exchange) error {
+	query := exchange.searchQuery()
+	switch s.Mode {
+	case ExpansionHyde:
+		completion, err := sendToLLM(ctx, s.Llm, fmt.Sprintf(hydePrompt, query), PromptConfig{temperature: s.Temperature})
+		if err != nil {
+			return err
+		}
+		exchange.Hypothetical = cleanupAnswer(completion)
+		log.Printf("Embedding the hypothetical answer %q instead of %q", exchange.Hypothetical, query)
+	case ExpansionMultiQuery:
+		if s.Queries <= 0 {
+			return nil
+		}
+		completion, err := sendToLLM(ctx, s.Llm, fmt.Sprintf(multiQueryPrompt, s.Queries, query), PromptConfig{temperature: s.Temperature})
+		if err != nil {
+			return err
+		}
+		exchange.Paraphrases = parseParaphrases(cleanupAnswer(completion), query, s.Queries)
+		log.Printf("Searching for %d paraphrases of %q: %q", len(exchange.Paraphrases), query, exchange.Paraphrases)
+	}
+	return nil
+}
+
+// parseParaphrases returns at most limit distinct lines of the completion that differ from the query
+func parseParaphrases(completion string, query string, limit int) []string {
+	seen := map[string]bool{strings.ToLower(query): true}
+	var paraphrases []string
+	for _, line := range strings.Split(completion, "\n") {
+		line = strings.Trim(listMarker.ReplaceAllString(line, ""), "\"' \t")
+		if line == "" || seen[strings.ToLower(line)] {
+			continue
+		}
+		seen[strings.ToLower(line)] = true
+		paraphrases = append(paraphrases, line)
+		if len(paraphrases) == limit {
+			break
+		}
+	}
+	return paraphrases
+}
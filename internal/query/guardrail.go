@@ -0,0 +1,224 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+type GuardrailFormat string
+
+const (
+	// LlamaGuardFormat expects "safe" or "unsafe" followed by a line of hazard categories like "S1,S10"
+	LlamaGuardFormat GuardrailFormat = "llama-guard"
+	// JSONFormat wraps the text into one of the moderation prompts and expects their JSON verdict
+	JSONFormat GuardrailFormat = "json"
+)
+
+type Decision string
+
+const (
+	Allow Decision = "ALLOW"
+	Block Decision = "BLOCK"
+)
+
+type GuardrailStage string
+
+const (
+	InputStage   GuardrailStage = "input"
+	ContextStage GuardrailStage = "context"
+	OutputStage  GuardrailStage = "output"
+)
+
+// see https://huggingface.co/meta-llama/Llama-Guard-3-1B
+var llamaGuardCategories = map[string]string{
+	"S1":  "Violent Crimes",
+	"S2":  "Non-Violent Crimes",
+	"S3":  "Sex-Related Crimes",
+	"S4":  "Child Sexual Exploitation",
+	"S5":  "Defamation",
+	"S6":  "Specialized Advice",
+	"S7":  "Privacy",
+	"S8":  "Intellectual Property",
+	"S9":  "Indiscriminate Weapons",
+	"S10": "Hate",
+	"S11": "Suicide & Self-Harm",
+	"S12": "Sexual Content",
+	"S13": "Elections",
+	"S14": "Code Interpreter Abuse",
+}
+
+type Verdict struct {
+	Decision   Decision `json:"decision"`
+	Categories []string `json:"categories"`
+	Confidence float64  `json:"confidence"`
+	Rationale  string   `json:"rationale"`
+}
+
+func (v *Verdict) Blocked() bool {
+	return v.Decision != Allow
+}
+
+type BlockedError struct {
+	Stage   GuardrailStage
+	Verdict Verdict
+}
+
+func (e *BlockedError) Error() string {
+	reason := "It does not conform to our standards"
+	if e.Stage == OutputStage {
+		reason = "The response might not be good for you"
+	}
+	if len(e.Verdict.Categories) > 0 {
+		reason = fmt.Sprintf("%s (%s)", reason, strings.Join(e.Verdict.Categories, ", "))
+	}
+	return fmt.Sprintf("cannot answer your query. %s", reason)
+}
+
+type Guardrail struct {
+	llm         llms.Model
+	stage       GuardrailStage
+	format      GuardrailFormat
+	temperature float64
+	// prompt and placeholder are only used by the JSON format
+	prompt      string
+	placeholder string
+}
+
+func NewInputGuardrail(llm llms.Model, format GuardrailFormat, temperature float64) *Guardrail {
+	return &Guardrail{
+		llm:         llm,
+		stage:       InputStage,
+		format:      format,
+		temperature: temperature,
+		prompt:      inputGuardrail,
+		placeholder: "{user_input}",
+	}
+}
+
+func NewOutputGuardrail(llm llms.Model, format GuardrailFormat, temperature float64) *Guardrail {
+	return &Guardrail{
+		llm:         llm,
+		stage:       OutputStage,
+		format:      format,
+		temperature: temperature,
+		prompt:      responseGuardrail,
+		placeholder: "{draft_output}",
+	}
+}
+
+// Check classifies the text and returns a BlockedError if the guardrail refuses it
+func (g *Guardrail) Check(ctx context.Context, text string) (*Verdict, error) {
+	log.Printf("Applying %s guardrail in %s format", g.stage, g.format)
+
+	prompt := text
+	if g.format == JSONFormat {
+		prompt = fillPrompt(g.prompt, g.placeholder, text)
+	}
+
+	completion, err := sendToLLM(ctx, g.llm, prompt, PromptConfig{temperature: g.temperature})
+	if err != nil {
+		return nil, err
+	}
+
+	verdict, err := ParseVerdict(g.format, completion)
+	if err != nil {
+		// err on the side of caution, like the prompts demand
+		log.Printf("Cannot parse guardrail verdict, blocking. %s", err)
+		verdict = &Verdict{Decision: Block, Categories: []string{}, Rationale: "unparseable guardrail verdict"}
+	}
+
+	if verdict.Blocked() {
+		log.Printf("Guardrail blocked the %s! Categories %v, reason %s", g.stage, verdict.Categories, verdict.Rationale)
+		return verdict, &BlockedError{Stage: g.stage, Verdict: *verdict}
+	}
+
+	return verdict, nil
+}
+
+// fillPrompt puts the text into the last placeholder of the prompt. The instructions name the placeholder as well,
+// the text must not end up there.
+func fillPrompt(prompt string, placeholder string, text string) string {
+	i := strings.LastIndex(prompt, placeholder)
+	if i < 0 {
+		return prompt + text
+	}
+	return prompt[:i] + text + prompt[i+len(placeholder):]
+}
+
+func ParseVerdict(format GuardrailFormat, completion string) (*Verdict, error) {
+	switch format {
+	case LlamaGuardFormat, "":
+		return parseLlamaGuardVerdict(completion)
+	case JSONFormat:
+		return parseJSONVerdict(completion)
+	default:
+		return nil, fmt.Errorf("unknown guardrail format %q", format)
+	}
+}
+
+func parseLlamaGuardVerdict(completion string) (*Verdict, error) {
+	lines := strings.Split(strings.TrimSpace(cleanupAnswer(completion)), "\n")
+
+	switch strings.ToLower(strings.TrimSpace(lines[0])) {
+	case "safe":
+		return &Verdict{Decision: Allow, Confidence: 1, Categories: []string{}}, nil
+	case "unsafe":
+		verdict := &Verdict{Decision: Block, Confidence: 1, Categories: []string{}}
+		if len(lines) > 1 {
+			for _, code := range strings.Split(lines[1], ",") {
+				code = strings.ToUpper(strings.TrimSpace(code))
+				if code == "" {
+					continue
+				}
+				if name, ok := llamaGuardCategories[code]; ok {
+					code = fmt.Sprintf("%s %s", code, name)
+				}
+				verdict.Categories = append(verdict.Categories, code)
+			}
+		}
+		verdict.Rationale = strings.Join(verdict.Categories, ", ")
+		return verdict, nil
+	default:
+		return nil, fmt.Errorf("unexpected llama-guard verdict %q", completion)
+	}
+}
+
+func parseJSONVerdict(completion string) (*Verdict, error) {
+	text := cleanupAnswer(completion)
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return nil, errors.New("guardrail verdict contains no JSON object")
+	}
+
+	var raw struct {
+		Decision       string   `json:"decision"`
+		Categories     []string `json:"categories"`
+		Confidence     float64  `json:"confidence"`
+		BriefRationale string   `json:"brief_rationale"`
+	}
+	if err := json.Unmarshal([]byte(text[start:end+1]), &raw); err != nil {
+		return nil, err
+	}
+
+	decision := Decision(strings.ToUpper(strings.TrimSpace(raw.Decision)))
+	if decision != Allow && decision != Block {
+		return nil, fmt.Errorf("unexpected guardrail decision %q", raw.Decision)
+	}
+	if raw.Categories == nil {
+		raw.Categories = []string{}
+	}
+
+	return &Verdict{
+		Decision:   decision,
+		Categories: raw.Categories,
+		Confidence: raw.Confidence,
+		Rationale:  raw.BriefRationale,
+	}, nil
+}
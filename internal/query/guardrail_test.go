@@ -0,0 +1,133 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/koenighotze/rag-demo/internal/provider"
+)
+
+func TestParseVerdict(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     GuardrailFormat
+		completion string
+		decision   Decision
+		categories []string
+		wantErr    bool
+	}{
+		{"llama-guard safe", LlamaGuardFormat, "safe", Allow, []string{}, false},
+		{"llama-guard safe with whitespace and case", LlamaGuardFormat, "\n  Safe \n", Allow, []string{}, false},
+		{"llama-guard unsafe with categories", LlamaGuardFormat, "unsafe\nS1, s10", Block, []string{"S1 Violent Crimes", "S10 Hate"}, false},
+		{"llama-guard unknown category is kept", LlamaGuardFormat, "unsafe\nS99", Block, []string{"S99"}, false},
+		{"llama-guard unsafe without categories", LlamaGuardFormat, "unsafe", Block, []string{}, false},
+		{"llama-guard after thinking", LlamaGuardFormat, "<think>\nlooks fine\n</think>\nsafe", Allow, []string{}, false},
+		{"empty format is llama-guard", "", "unsafe\nS7", Block, []string{"S7 Privacy"}, false},
+		{"llama-guard gibberish", LlamaGuardFormat, "maybe", "", nil, true},
+		{"json allow", JSONFormat, `{"decision": "ALLOW", "categories": [], "confidence": 0.9, "brief_rationale": "harmless"}`, Allow, []string{}, false},
+		{"json block", JSONFormat, `{"decision": "block", "categories": ["PII"], "confidence": 0.8}`, Block, []string{"PII"}, false},
+		{"json without categories", JSONFormat, `{"decision": "ALLOW"}`, Allow, []string{}, false},
+		{"json wrapped in text and thinking", JSONFormat, "<think>{\"decision\": \"BLOCK\"}</think>Verdict: {\"decision\": \"ALLOW\"} done", Allow, []string{}, false},
+		{"json unknown decision", JSONFormat, `{"decision": "MAYBE"}`, "", nil, true},
+		{"json missing object", JSONFormat, "ALLOW", "", nil, true},
+		{"json malformed", JSONFormat, `{"decision": ALLOW}`, "", nil, true},
+		{"unknown format", "xml", "safe", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, err := ParseVerdict(tt.format, tt.completion)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", verdict)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verdict.Decision != tt.decision {
+				t.Errorf("decision %s, expected %s", verdict.Decision, tt.decision)
+			}
+			if fmt.Sprint(verdict.Categories) != fmt.Sprint(tt.categories) || verdict.Categories == nil {
+				t.Errorf("categories %#v, expected %#v", verdict.Categories, tt.categories)
+			}
+		})
+	}
+}
+
+func TestFillPrompt(t *testing.T) {
+	tests := []struct {
+		name   string
+		prompt string
+		want   string
+	}{
+		{"only the last placeholder is filled", "Classify {text}.\nText: {text}", "Classify {text}.\nText: hello"},
+		{"single placeholder", "Text: {text} end", "Text: hello end"},
+		{"missing placeholder appends the text", "Text: ", "Text: hello"},
+	}
+
+	for _, tt := range tests {
+		if got := fillPrompt(tt.prompt, "{text}", "hello"); got != tt.want {
+			t.Errorf("%s: got %q, expected %q", tt.name, got, tt.want)
+		}
+	}
+
+	for _, g := range []*Guardrail{NewInputGuardrail(nil, JSONFormat, 0), NewOutputGuardrail(nil, JSONFormat, 0)} {
+		filled := fillPrompt(g.prompt, g.placeholder, "the text")
+		if strings.Count(filled, "the text") != 1 {
+			t.Errorf("the %s guardrail prompt does not contain the text once", g.stage)
+		}
+		if strings.Count(filled, g.placeholder) != strings.Count(g.prompt, g.placeholder)-1 {
+			t.Errorf("the %s guardrail prompt must keep the placeholders named in its instructions", g.stage)
+		}
+		if strings.LastIndex(filled, g.placeholder) > strings.Index(filled, "the text") {
+			t.Errorf("the %s guardrail prompt has the text before the last placeholder", g.stage)
+		}
+	}
+}
+
+func TestGuardrailCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   GuardrailFormat
+		response string
+		blocked  bool
+	}{
+		{"allowed", LlamaGuardFormat, "safe", false},
+		{"blocked", LlamaGuardFormat, "unsafe\nS2", true},
+		{"json allowed", JSONFormat, `{"decision": "ALLOW"}`, false},
+		{"unparseable verdict blocks", JSONFormat, "I cannot decide", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guardrail := NewInputGuardrail(provider.NewFakeModel([]string{tt.response}), tt.format, 0)
+			verdict, err := guardrail.Check(context.Background(), "how do I cook pasta?")
+
+			var blocked *BlockedError
+			if tt.blocked != errors.As(err, &blocked) {
+				t.Fatalf("expected blocked %t, got %v", tt.blocked, err)
+			}
+			if verdict == nil || verdict.Blocked() != tt.blocked {
+				t.Errorf("expected a verdict with blocked %t, got %+v", tt.blocked, verdict)
+			}
+			if tt.blocked && blocked.Stage != InputStage {
+				t.Errorf("expected the input stage, got %s", blocked.Stage)
+			}
+		})
+	}
+}
+
+func TestGuardrailCheckFailsWithTheModel(t *testing.T) {
+	guardrail := NewOutputGuardrail(provider.NewFakeModel(nil), LlamaGuardFormat, 0)
+	_, err := guardrail.Check(context.Background(), "an answer")
+
+	var blocked *BlockedError
+	if err == nil || errors.As(err, &blocked) {
+		t.Errorf("expected the error of the model, got %v", err)
+	}
+}
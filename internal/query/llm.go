@@ -2,22 +2,46 @@ package query
 
 import (
 	"context"
+	"errors"
 	"log"
 
 	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/llms/ollama"
 )
 
 type PromptConfig struct {
 	temperature float64
 }
 
-func sendToLLM(llm *ollama.LLM, query string, config PromptConfig) (string, error) {
+func sendToLLM(ctx context.Context, llm llms.Model, query string, config PromptConfig) (string, error) {
 	log.Printf("Sending query '%s' to LLM\n", query)
-	completion, err := llms.GenerateFromSinglePrompt(context.Background(), llm, query, llms.WithTemperature(config.temperature))
+	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, query, llms.WithTemperature(config.temperature))
 	if err != nil {
 		return "", err
 	}
 	log.Printf("LLM answered with '%s'\n", completion)
 	return completion, nil
 }
+
+func chatWithLLM(ctx context.Context, llm llms.Model, messages []llms.MessageContent, config PromptConfig) (string, error) {
+	log.Printf("Sending %d chat messages to LLM, the last one is '%v'\n", len(messages), messages[len(messages)-1].Parts)
+	response, err := llm.GenerateContent(ctx, messages, llms.WithTemperature(config.temperature))
+	if err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", errors.New("the LLM returned no answer")
+	}
+	completion := response.Choices[0].Content
+	log.Printf("LLM answered with '%s'\n", completion)
+	return completion, nil
+}
+
+func streamToLLM(ctx context.Context, llm llms.Model, query string, config PromptConfig, streamingFunc func(ctx context.Context, chunk []byte) error) (string, error) {
+	log.Printf("Streaming query '%s' to LLM\n", query)
+	completion, err := llms.GenerateFromSinglePrompt(ctx, llm, query, llms.WithTemperature(config.temperature), llms.WithStreamingFunc(streamingFunc))
+	if err != nil {
+		return "", err
+	}
+	log.Printf("LLM streamed '%s'\n", completion)
+	return completion, nil
+}
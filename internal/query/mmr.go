@@ -0,0 +1,69 @@
+package query
+
+import (
+	"context"
+	"log"
+
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+)
+
+// MmrStage re-selects the retrieved chunks by maximal marginal relevance. It picks TopK chunks one at a time,
+// each time the one with the best lambda * relevance - (1 - lambda) * similarity to the chunks picked before.
+// The relevance is the rerank score if a reranker ran and the similarity to the query otherwise.
+type MmrStage struct {
+	Lambda float64
+	TopK   uint64
+}
+
+func (s *MmrStage) Name() string { return "mmr" }
+
+func (s *MmrStage) Run(_ context.Context, exchange *Exchange) error {
+	rerankScores := map[string]*float32{}
+	for _, c := range exchange.Candidates {
+		rerankScores[c.ChunkId] = c.RerankScore
+	}
+
+	remaining := exchange.Results
+	relevance := make([]float64, len(remaining))
+	for i, r := range remaining {
+		if score := rerankScores[r.Id]; score != nil {
+			relevance[i] = float64(*score)
+		} else {
+			relevance[i] = float64(vectordb.CosineSimilarity(exchange.QueryEmbedding, r.Item.Embedding))
+		}
+		if len(r.Item.Embedding) == 0 {
+			log.Printf("Chunk %s has no vector, it counts as different from all others", r.Id)
+		}
+	}
+
+	var selected []*vectordb.SearchResult
+	for len(remaining) > 0 && (s.TopK == 0 || uint64(len(selected)) < s.TopK) {
+		best, bestScore := 0, 0.0
+		for i, r := range remaining {
+			similarity := 0.0
+			for _, picked := range selected {
+				similarity = max(similarity, float64(vectordb.CosineSimilarity(r.Item.Embedding, picked.Item.Embedding)))
+			}
+			score := s.Lambda*relevance[i] - (1-s.Lambda)*similarity
+			if i == 0 || score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		picked := remaining[best]
+		log.Printf("Selected chunk %s from %s with a marginal relevance of %f", picked.Id, picked.Item.SourceDocument, bestScore)
+		selected = append(selected, picked)
+		remaining = append(remaining[:best:best], remaining[best+1:]...)
+		relevance = append(relevance[:best:best], relevance[best+1:]...)
+	}
+
+	for i := range exchange.Candidates {
+		for rank, r := range selected {
+			if r.Id == exchange.Candidates[i].ChunkId {
+				exchange.Candidates[i].MmrRank = rank + 1
+			}
+		}
+	}
+	exchange.Results = selected
+	return nil
+}
@@ -0,0 +1,87 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/koenighotze/rag-demo/internal/embedding"
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+)
+
+func chunk(id string, vector ...float32) *vectordb.SearchResult {
+	return &vectordb.SearchResult{Id: id, Item: embedding.KnowledgeItem{Id: id, Embedding: vector}}
+}
+
+func TestMmrStage(t *testing.T) {
+	// a and duplicate are near duplicates close to the query, other is less relevant but different
+	query := []float32{1, 0, 0}
+	retrieved := func() []*vectordb.SearchResult {
+		return []*vectordb.SearchResult{
+			chunk("a", 1, 0.1, 0),
+			chunk("duplicate", 1, 0.1, 0.01),
+			chunk("other", 0.5, 0, 0.866),
+		}
+	}
+	score := func(s float32) *float32 { return &s }
+
+	tests := []struct {
+		name   string
+		lambda float64
+		topK   uint64
+		rerank map[string]*float32
+		want   []string
+	}{
+		{"relevance only keeps the retrieval order", 1, 2, nil, []string{"a", "duplicate"}},
+		{"diversity skips the near duplicate", 0.3, 2, nil, []string{"a", "other"}},
+		{"no top_k orders all chunks", 0.3, 0, nil, []string{"a", "other", "duplicate"}},
+		{"top_k above the number of chunks", 1, 5, nil, []string{"a", "duplicate", "other"}},
+		{"rerank scores replace the similarity to the query", 1, 2,
+			map[string]*float32{"a": score(0.1), "duplicate": score(0.2), "other": score(0.9)},
+			[]string{"other", "duplicate"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exchange := &Exchange{QueryEmbedding: query, Results: retrieved()}
+			for _, r := range exchange.Results {
+				exchange.Candidates = append(exchange.Candidates, Candidate{ChunkId: r.Id, RerankScore: tt.rerank[r.Id]})
+			}
+
+			stage := &MmrStage{Lambda: tt.lambda, TopK: tt.topK}
+			if err := stage.Run(context.Background(), exchange); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, r := range exchange.Results {
+				got = append(got, r.Id)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("selected %v, expected %v", got, tt.want)
+			}
+
+			for _, c := range exchange.Candidates {
+				wantRank := 0
+				for rank, id := range tt.want {
+					if id == c.ChunkId {
+						wantRank = rank + 1
+					}
+				}
+				if c.MmrRank != wantRank {
+					t.Errorf("mmr rank of %s is %d, expected %d", c.ChunkId, c.MmrRank, wantRank)
+				}
+			}
+		})
+	}
+}
+
+func TestMmrStageWithoutResults(t *testing.T) {
+	exchange := &Exchange{QueryEmbedding: []float32{1, 0}}
+	if err := (&MmrStage{Lambda: 0.5, TopK: 3}).Run(context.Background(), exchange); err != nil {
+		t.Fatal(err)
+	}
+	if len(exchange.Results) != 0 {
+		t.Errorf("expected no results, got %d", len(exchange.Results))
+	}
+}
@@ -0,0 +1,334 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/koenighotze/rag-demo/internal/embedding"
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+	"github.com/tmc/langchaingo/llms"
+	"golang.org/x/sync/errgroup"
+)
+
+// Exchange carries a single query and everything derived from it through the pipeline
+type Exchange struct {
+	Query string
+	// SearchQuery replaces the query for retrieval and reranking if it is set, e.g. a condensed follow-up question
+	SearchQuery string
+	// Hypothetical is embedded instead of the search query if it is set (hyde)
+	Hypothetical string
+	// Paraphrases are searched in addition to the search query (multi_query)
+	Paraphrases []string
+	// QueryEmbedding is set by the retrieval stage
+	QueryEmbedding []float32
+	// Retrieved is set once a retrieval stage ran, even if it found nothing
+	Retrieved bool
+	Results   []*vectordb.SearchResult
+	// Candidates are all retrieved chunks in retrieval order with their scores
+	Candidates []Candidate
+	Sources    []Source
+	Prompt     string
+	Completion string
+	Answer     string
+	Verdict    *Verdict
+}
+
+// searchQuery is the text to retrieve chunks for
+func (e *Exchange) searchQuery() string {
+	if e.SearchQuery != "" {
+		return e.SearchQuery
+	}
+	return e.Query
+}
+
+type Stage interface {
+	Name() string
+	Run(ctx context.Context, exchange *Exchange) error
+}
+
+type Pipeline struct {
+	stages []Stage
+}
+
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+func (p *Pipeline) Run(ctx context.Context, query string) (*Exchange, error) {
+	exchange := &Exchange{Query: query}
+	for _, stage := range p.stages {
+		log.Printf("Running pipeline stage %s", stage.Name())
+		if err := stage.Run(ctx, exchange); err != nil {
+			return exchange, err
+		}
+	}
+	return exchange, nil
+}
+
+type InputGuardrailStage struct {
+	Guardrail *Guardrail
+}
+
+func (s *InputGuardrailStage) Name() string { return "input-guardrail" }
+
+func (s *InputGuardrailStage) Run(ctx context.Context, exchange *Exchange) error {
+	_, err := s.Guardrail.Check(ctx, exchange.Query)
+	return err
+}
+
+type RetrievalStage struct {
+	Store    vectordb.VectorStore
+	Embedder embedding.Embedder
+	// Limit is the number of chunks to retrieve, top_k or the number of candidates for rerank and mmr
+	Limit uint64
+	// WithVectors retrieves the vectors of the chunks, mmr compares them
+	WithVectors bool
+	Options     RetrievalOptions
+}
+
+func (s *RetrievalStage) Name() string { return "retrieval" }
+
+func (s *RetrievalStage) Run(ctx context.Context, exchange *Exchange) error {
+	query := exchange.searchQuery()
+	embedText := query
+	if exchange.Hypothetical != "" {
+		embedText = exchange.Hypothetical
+	}
+	queryEmbedding, results, err := withVectorStore(ctx, s.Embedder, s.Store, query, embedText, s.Limit, s.WithVectors, s.Options)
+	if err != nil {
+		return err
+	}
+
+	if len(exchange.Paraphrases) > 0 {
+		rankings := [][]*vectordb.SearchResult{results}
+		for _, paraphrase := range exchange.Paraphrases {
+			_, paraphraseResults, err := withVectorStore(ctx, s.Embedder, s.Store, paraphrase, paraphrase, s.Limit, s.WithVectors, s.Options)
+			if err != nil {
+				return err
+			}
+			rankings = append(rankings, paraphraseResults)
+		}
+		// the results keep their best similarity, the fused score decides their order
+		results = vectordb.Fuse(rankings, s.Limit)
+		log.Printf("Fused %d searches into %d chunks", len(rankings), len(results))
+	}
+
+	exchange.QueryEmbedding = queryEmbedding
+	exchange.Results = results
+	exchange.Candidates = candidates(results)
+	exchange.Retrieved = true
+	return nil
+}
+
+// ContextBudgetStage keeps the chunks that fit into the token budget of the context. The tokens are estimated
+// by the length of the chunks, see estimateTokens.
+type ContextBudgetStage struct {
+	ContextTokenBudget int
+}
+
+func (s *ContextBudgetStage) Name() string { return "context-budget" }
+
+func (s *ContextBudgetStage) Run(_ context.Context, exchange *Exchange) error {
+	exchange.Results = selectContext(exchange.Results, s.ContextTokenBudget)
+	return nil
+}
+
+// contextGuardrailCalls bounds the number of chunks the context guardrail checks at the same time
+const contextGuardrailCalls = 4
+
+// ContextGuardrailStage drops retrieved chunks the guardrail refuses instead of failing the whole query.
+// The chunks are checked concurrently, the accepted ones keep their order.
+type ContextGuardrailStage struct {
+	Guardrail *Guardrail
+}
+
+func (s *ContextGuardrailStage) Name() string { return "context-guardrail" }
+
+func (s *ContextGuardrailStage) Run(ctx context.Context, exchange *Exchange) error {
+	refused := make([]bool, len(exchange.Results))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(contextGuardrailCalls)
+	for i, r := range exchange.Results {
+		group.Go(func() error {
+			_, err := s.Guardrail.Check(groupCtx, r.Item.Chunk)
+			var blocked *BlockedError
+			if errors.As(err, &blocked) {
+				log.Printf("Dropping chunk %s from %s. Categories: %v", r.Id, r.Item.SourceDocument, blocked.Verdict.Categories)
+				refused[i] = true
+				return nil
+			}
+			return err
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	var accepted []*vectordb.SearchResult
+	for i, r := range exchange.Results {
+		if !refused[i] {
+			accepted = append(accepted, r)
+		}
+	}
+	exchange.Results = accepted
+	return nil
+}
+
+type GenerationStage struct {
+	Llm         llms.Model
+	Temperature float64
+}
+
+func (s *GenerationStage) Name() string { return "generation" }
+
+func (s *GenerationStage) Run(ctx context.Context, exchange *Exchange) error {
+	augment(exchange)
+
+	completion, err := sendToLLM(ctx, s.Llm, exchange.Prompt, PromptConfig{temperature: s.Temperature})
+	if err != nil {
+		return err
+	}
+	exchange.Completion = completion
+	return nil
+}
+
+type OutputGuardrailStage struct {
+	Guardrail *Guardrail
+}
+
+func (s *OutputGuardrailStage) Name() string { return "output-guardrail" }
+
+func (s *OutputGuardrailStage) Run(ctx context.Context, exchange *Exchange) error {
+	verdict, err := s.Guardrail.Check(ctx, exchange.Completion)
+	exchange.Verdict = verdict
+	if err != nil {
+		return err
+	}
+
+	exchange.Answer = cleanupAnswer(exchange.Completion)
+	return nil
+}
+
+// augment turns the retrieved chunks into sources and the prompt. Without retrieval the query is the prompt.
+func augment(exchange *Exchange) {
+	if !exchange.Retrieved {
+		exchange.Sources = []Source{}
+		exchange.Prompt = exchange.Query
+		return
+	}
+
+	exchange.Sources = sourcesFromSearchResults(exchange.Results)
+	exchange.Prompt = buildRAGPrompt(exchange.Query, exchange.Sources, exchange.Results)
+	markSelected(exchange.Candidates, exchange.Results)
+}
+
+func (s *Service) inputGuardrail() *Guardrail {
+	return NewInputGuardrail(s.inputGuardrailLlm, GuardrailFormat(s.config.InputGuardrailFormat), s.config.InputTemperature)
+}
+
+func (s *Service) outputGuardrail() *Guardrail {
+	return NewOutputGuardrail(s.outputGuardrailLlm, GuardrailFormat(s.config.OutputGuardrailFormat), s.config.OutputTemperature)
+}
+
+func (s *Service) contextGuardrail() *Guardrail {
+	guardrail := s.inputGuardrail()
+	guardrail.stage = ContextStage
+	return guardrail
+}
+
+// retrievalStages returns the query expansion if any, the retrieval stage, the reranker if there is one, mmr if enabled,
+// the context budget and, if enabled, the guardrail for the retrieved context. Reranker and mmr over-fetch candidates
+// to select top_k from.
+func (s *Service) retrievalStages(store vectordb.VectorStore, options RetrievalOptions) []Stage {
+	lambda, mmr := s.mmrLambda(options)
+	retrieval := &RetrievalStage{
+		Store:       store,
+		Embedder:    s.embedder,
+		Limit:       s.config.TopK,
+		WithVectors: mmr,
+		Options:     options,
+	}
+	var stages []Stage
+	if mode := s.expansionMode(options); mode != ExpansionNone {
+		stages = append(stages, &ExpansionStage{
+			Llm:         s.expansionLlm,
+			Mode:        mode,
+			Queries:     s.config.Expansion.Queries,
+			Temperature: s.config.Expansion.Temperature,
+		})
+	}
+	stages = append(stages, retrieval)
+	if s.reranker != nil {
+		retrieval.Limit = max(retrieval.Limit, s.rerankCandidates())
+		rerank := &RerankStage{Reranker: s.reranker, TopK: s.config.TopK}
+		if mmr {
+			// mmr selects from all reranked candidates
+			rerank.TopK = 0
+		}
+		stages = append(stages, rerank)
+	}
+	if mmr {
+		retrieval.Limit = max(retrieval.Limit, s.mmrCandidates())
+		stages = append(stages, &MmrStage{Lambda: lambda, TopK: s.config.TopK})
+	}
+	stages = append(stages, &ContextBudgetStage{ContextTokenBudget: s.config.ContextTokenBudget})
+	if s.config.ContextGuardrailEnabled {
+		stages = append(stages, &ContextGuardrailStage{Guardrail: s.contextGuardrail()})
+	}
+	return stages
+}
+
+// expansionMode is the mode of the request or the configured one
+func (s *Service) expansionMode(options RetrievalOptions) string {
+	mode := options.Expansion
+	if mode == "" {
+		mode = s.config.Expansion.Mode
+	}
+	if mode == "" {
+		return ExpansionNone
+	}
+	return mode
+}
+
+// rerankCandidates is the number of chunks to over-fetch for the reranker, four times top_k unless configured
+func (s *Service) rerankCandidates() uint64 {
+	if s.config.Rerank.Candidates > 0 {
+		return s.config.Rerank.Candidates
+	}
+	return 4 * s.config.TopK
+}
+
+// mmrLambda reports whether mmr applies, a lambda in the request enables it for that request
+func (s *Service) mmrLambda(options RetrievalOptions) (float64, bool) {
+	if options.MmrLambda != nil {
+		return *options.MmrLambda, true
+	}
+	return s.config.Mmr.Lambda, s.config.Mmr.Enabled
+}
+
+// mmrCandidates is the number of chunks to over-fetch for mmr, four times top_k unless configured
+func (s *Service) mmrCandidates() uint64 {
+	if s.config.Mmr.Candidates > 0 {
+		return s.config.Mmr.Candidates
+	}
+	return 4 * s.config.TopK
+}
+
+func (s *Service) PlainPipeline() *Pipeline {
+	return NewPipeline(
+		&InputGuardrailStage{Guardrail: s.inputGuardrail()},
+		&GenerationStage{Llm: s.llm, Temperature: s.config.MainTemperature},
+		&OutputGuardrailStage{Guardrail: s.outputGuardrail()},
+	)
+}
+
+func (s *Service) RAGPipeline(store vectordb.VectorStore, options RetrievalOptions) *Pipeline {
+	stages := []Stage{&InputGuardrailStage{Guardrail: s.inputGuardrail()}}
+	stages = append(stages, s.retrievalStages(store, options)...)
+	stages = append(stages,
+		&GenerationStage{Llm: s.llm, Temperature: s.config.MainTemperature},
+		&OutputGuardrailStage{Guardrail: s.outputGuardrail()},
+	)
+	return NewPipeline(stages...)
+}
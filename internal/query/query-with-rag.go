@@ -1,71 +1,158 @@
 package query
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"strings"
+	"unicode/utf8"
 
-	"github.com/koenighotze/rag-demo/config"
 	"github.com/koenighotze/rag-demo/internal/embedding"
 	"github.com/koenighotze/rag-demo/internal/vectordb"
-	"github.com/tmc/langchaingo/llms/ollama"
 )
 
-func withQdrant(query string) (string, error) {
-	embedder := embedding.Default()
+const contextSeparator = "\n\n---\n\n"
+
+// charsPerToken approximates the tokenizers of the local models. llms.CountTokens only knows the openai encodings,
+// so the token budgets are estimates with this many characters per token.
+const charsPerToken = 4
+
+// RetrievalOptions tune the retrieval of a single request
+type RetrievalOptions struct {
+	SearchConfig *vectordb.SearchOverrides `json:"search_config"`
+	// Filter restricts the context to chunks with matching metadata
+	Filter *vectordb.Filter `json:"filter"`
+	// Debug adds the retrieved candidates with their scores to the response
+	Debug bool `json:"debug"`
+	// MmrLambda selects the context by maximal marginal relevance with this lambda, even if query.mmr is disabled
+	MmrLambda *float64 `json:"mmr_lambda"`
+	// Expansion is one of none, hyde or multi_query and replaces query.expansion.mode for this request
+	Expansion string `json:"expansion"`
+}
 
-	item, err := embedder.EmbedDocument(query)
-	if err != nil {
-		return "", err
+// Validate checks the values a request can set, the configuration is validated on load
+func (o RetrievalOptions) Validate() error {
+	if o.MmrLambda != nil && (*o.MmrLambda < 0 || *o.MmrLambda > 1) {
+		return fmt.Errorf("mmr_lambda must be between 0 and 1, got %g", *o.MmrLambda)
+	}
+	if err := o.SearchConfig.Validate(); err != nil {
+		return err
+	}
+	switch o.Expansion {
+	case "", ExpansionNone, ExpansionHyde, ExpansionMultiQuery:
+	default:
+		return fmt.Errorf("expansion must be one of %s, %s or %s, got %q", ExpansionNone, ExpansionHyde, ExpansionMultiQuery, o.Expansion)
 	}
+	return nil
+}
 
-	client := vectordb.DefaultVectorDbClient()
+// withVectorStore embeds embedText and searches the store, query is the text for the sparse search of a hybrid search.
+// It returns the embedding with the results.
+func withVectorStore(ctx context.Context, embedder embedding.Embedder, store vectordb.VectorStore, query string, embedText string, topK uint64, withVectors bool, options RetrievalOptions) ([]float32, []*vectordb.SearchResult, error) {
+	item, err := embedder.EmbedDocument(ctx, embedText)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	res, err := client.ExecuteSearch(item.Embedding)
+	res, err := store.Search(vectordb.SearchRequest{
+		Vector:      item.Embedding,
+		Text:        query,
+		Limit:       topK,
+		Overrides:   options.SearchConfig,
+		Filter:      options.Filter,
+		WithVectors: withVectors,
+	})
 
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
 	if len(res) < 1 {
 		log.Println("No context found for query")
-		return "", nil
 	}
 
-	return res[0].Item.Chunk, nil
+	return item.Embedding, res, nil
 }
 
-func GenerateAnswerWithRAG(llm *ollama.LLM, guardRailLlm *ollama.LLM, query string) (string, error) {
-	log.Printf("Generating answer for query with qdrant: %s", query)
-
-	additionalContext, err := withQdrant(query)
+// estimateTokens approximates the number of tokens of the text, see charsPerToken
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
 
-	if err != nil {
-		return "", err
+// selectContext picks the chunks in score order until the estimated token budget is used up.
+// A budget of 0 or less means no limit.
+func selectContext(results []*vectordb.SearchResult, tokenBudget int) []*vectordb.SearchResult {
+	var selected []*vectordb.SearchResult
+	usedTokens := 0
+	for _, r := range results {
+		tokens := estimateTokens(r.Item.Chunk)
+		if tokenBudget > 0 && usedTokens+tokens > tokenBudget {
+			log.Printf("Skipping chunk %s with %d tokens, it does not fit into the context budget of %d tokens", r.Id, tokens, tokenBudget)
+			continue
+		}
+		usedTokens += tokens
+		selected = append(selected, r)
 	}
+	log.Printf("Using %d of %d retrieved chunks with %d tokens as context", len(selected), len(results), usedTokens)
 
-	prompt := fmt.Sprintf(`You are a helpful assistant.
-Answer the user and consider the context below as your primary context.
+	return selected
+}
 
-Context:
-%s
+// buildContext labels every chunk with the citation marker of its source
+func buildContext(sources []Source, results []*vectordb.SearchResult) string {
+	var chunks []string
+	for i, r := range results {
+		chunks = append(chunks, fmt.Sprintf("[%d] (source: %s, %s)\n%s", sources[i].Index, sources[i].Path, sources[i].pages(), r.Item.Chunk))
+	}
+	return strings.Join(chunks, contextSeparator)
+}
 
-Question: %s`, additionalContext, query)
+func buildRAGPrompt(query string, sources []Source, results []*vectordb.SearchResult) string {
+	additionalContext := buildContext(sources, results)
 
 	if additionalContext == "" {
-		prompt = fmt.Sprintf(`You are a helpful assistant.
+		return fmt.Sprintf(`You are a helpful assistant.
 Answer the following question:
 
 Question: %s`, query)
 	}
 
-	log.Println(query)
-	completion, err := sendToLLM(llm, prompt, PromptConfig{temperature: config.Default().Query.MainTemperature})
-	if err != nil {
-		return "", err
+	return fmt.Sprintf(`You are a helpful assistant.
+Answer the user and consider the context below as your primary context.
+Each context entry starts with a numbered marker like [1].
+Cite the entries you use with their marker inline, for example "... was rewritten [2]."
+Do not invent markers that are not listed in the context.
+
+Context:
+%s
+
+Question: %s`, additionalContext, query)
+}
+
+func debug(exchange *Exchange, options RetrievalOptions) *Debug {
+	if !options.Debug {
+		return nil
+	}
+	return &Debug{
+		Query:        exchange.Query,
+		SearchQuery:  exchange.SearchQuery,
+		Hypothetical: exchange.Hypothetical,
+		Paraphrases:  exchange.Paraphrases,
+		Candidates:   exchange.Candidates,
 	}
+}
 
-	sanitizedAnswer, err := ApplyResponseGuardrail(guardRailLlm, completion)
+func (s *Service) GenerateAnswerWithRAG(ctx context.Context, store vectordb.VectorStore, options RetrievalOptions, query string) (*Answer, error) {
+	log.Printf("Generating answer for query with vector store: %s", query)
 
-	return sanitizedAnswer, err
+	exchange, err := s.RAGPipeline(store, options).Run(ctx, query)
+	if err != nil {
+		return nil, err
+	}
 
+	return &Answer{
+		Answer:  exchange.Answer,
+		Sources: exchange.Sources,
+		Debug:   debug(exchange, options),
+	}, nil
 }
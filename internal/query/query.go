@@ -1,27 +1,46 @@
 package query
 
 import (
+	"context"
 	"log"
 
 	"github.com/koenighotze/rag-demo/config"
-	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/koenighotze/rag-demo/internal/embedding"
+	"github.com/tmc/langchaingo/llms"
 )
 
-func GeneratePlainAnswer(llm *ollama.LLM, guardRailLlm *ollama.LLM, query string) (string, error) {
-	log.Printf("Generating plain answer: %s", query)
+// Service answers queries with the models and settings of a single configuration
+type Service struct {
+	llm                llms.Model
+	inputGuardrailLlm  llms.Model
+	outputGuardrailLlm llms.Model
+	// expansionLlm writes the hypothetical answers and paraphrases of the query expansion
+	expansionLlm llms.Model
+	// reranker is nil if the retrieved chunks are used in the order of the vector store
+	reranker Reranker
+	embedder embedding.Embedder
+	config   config.Query
+}
 
-	sanitizedQuery, err := ApplyRequestGuardrail(guardRailLlm, query)
-	if err != nil {
-		return "", err
+func NewService(llm llms.Model, inputGuardrailLlm llms.Model, outputGuardrailLlm llms.Model, expansionLlm llms.Model, reranker Reranker, embedder embedding.Embedder, queryConfig config.Query) *Service {
+	return &Service{
+		llm:                llm,
+		inputGuardrailLlm:  inputGuardrailLlm,
+		outputGuardrailLlm: outputGuardrailLlm,
+		expansionLlm:       expansionLlm,
+		reranker:           reranker,
+		embedder:           embedder,
+		config:             queryConfig,
 	}
+}
+
+func (s *Service) GeneratePlainAnswer(ctx context.Context, query string) (string, error) {
+	log.Printf("Generating plain answer: %s", query)
 
-	// TODO refactor
-	completion, err := sendToLLM(llm, sanitizedQuery, PromptConfig{temperature: config.Default().Query.MainTemperature})
+	exchange, err := s.PlainPipeline().Run(ctx, query)
 	if err != nil {
 		return "", err
 	}
 
-	sanitizedAnswer, err := ApplyResponseGuardrail(guardRailLlm, completion)
-
-	return sanitizedAnswer, err
+	return exchange.Answer, nil
 }
@@ -0,0 +1,55 @@
+package query
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/koenighotze/rag-demo/config"
+	"github.com/koenighotze/rag-demo/internal/embedding"
+	"github.com/koenighotze/rag-demo/internal/provider"
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+)
+
+func TestGenerateAnswerWithRAG(t *testing.T) {
+	ctx := context.Background()
+	embedder, err := embedding.NewEmbedder(config.Embedding{ModelName: "fake", ChunkStrategy: "recursive", ChunkSize: 1000}, provider.NewFakeModel(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := []*embedding.KnowledgeItem{
+		{Id: "0b5f4c0e-0000-4000-8000-000000000001", SourceDocument: "corpus/mainframe.pdf", StartPage: 3, EndPage: 4, Chunk: "The mainframe migration moves the batch jobs to the cloud."},
+		{Id: "0b5f4c0e-0000-4000-8000-000000000002", SourceDocument: "corpus/recipes.md", Chunk: "Cook pasta in salted water."},
+	}
+	if err := embedder.EmbedItems(ctx, items); err != nil {
+		t.Fatal(err)
+	}
+	store := vectordb.NewInMemoryVectorStore(nil)
+	if err := store.AddPointsToCollection(items); err != nil {
+		t.Fatal(err)
+	}
+
+	guardrail := provider.NewFakeModel([]string{"safe"})
+	llm := provider.NewFakeModel([]string{"The batch jobs move to the cloud [1]."})
+	service := NewService(llm, guardrail, guardrail, llm, nil, embedder, config.Query{TopK: 2})
+
+	answer, err := service.GenerateAnswerWithRAG(ctx, store, RetrievalOptions{}, "Where do the mainframe batch jobs move?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(answer.Answer, "cloud [1]") {
+		t.Errorf("expected the answer of the model, got %q", answer.Answer)
+	}
+	if len(answer.Sources) != 1 {
+		t.Fatalf("expected only the mainframe chunk above the score threshold, got %+v", answer.Sources)
+	}
+	source := answer.Sources[0]
+	if source.Index != 1 || source.Path != "corpus/mainframe.pdf" || source.Page != 3 || source.EndPage != 4 || source.ChunkId != items[0].Id {
+		t.Errorf("expected the mainframe chunk as source [1], got %+v", source)
+	}
+	if source.Score < 0.3 || source.Score > 1 {
+		t.Errorf("expected the similarity as score, got %g", source.Score)
+	}
+}
@@ -1,14 +1,5 @@
 package query
 
-import (
-	"errors"
-	"log"
-
-	"github.com/koenighotze/rag-demo/config"
-	"github.com/tmc/langchaingo/llms/ollama"
-)
-
-//nolint:unused
 const inputGuardrail = `SYSTEM - Guardrail / Moderation Layer
 You are **SafetySentinel**, an impartial content-safety classifier.
 Your only task is to inspect the single user prompt supplied in the
@@ -77,20 +68,3 @@ IV. OPERATIONAL NOTES
 ────────────────────────────────────────────────────────────────────────
 {user_input}
 `
-
-func ApplyRequestGuardrail(guardRailLlm *ollama.LLM, rawQuery string) (sanitized string, err error) {
-	log.Println("Applying request guardrail")
-	completion, err := sendToLLM(guardRailLlm, rawQuery, PromptConfig{temperature: config.Default().Query.InputTemperature})
-	if err != nil {
-		return "", err
-	}
-	log.Printf("LLM answered with '%s'\n", completion)
-
-	if completion != "safe" {
-		// We could use the larger model and check the reasons better
-		log.Printf("Unsafe query! Reason %s", completion)
-		return "", errors.New("cannot answer your query. It does not conform to our standards")
-	}
-
-	return rawQuery, nil
-}
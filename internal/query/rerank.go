@@ -0,0 +1,202 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/koenighotze/rag-demo/internal/embedding"
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// strategies of config.Rerank
+const (
+	RerankNone    = "none"
+	RerankLexical = "lexical"
+	RerankLlm     = "llm"
+)
+
+// Reranker scores the retrieved chunks against the query, a higher score is more relevant.
+// It returns one score per result in the same order.
+type Reranker interface {
+	Name() string
+	Score(ctx context.Context, query string, results []*vectordb.SearchResult) ([]float32, error)
+}
+
+// Candidate shows how a retrieved chunk was ranked, it is part of the debug output
+type Candidate struct {
+	ChunkId        string  `json:"chunk_id"`
+	Path           string  `json:"path"`
+	RetrievalRank  int     `json:"retrieval_rank"`
+	RetrievalScore float32 `json:"retrieval_score"`
+	// FusedScore is set if the retrieval fused several rankings, it decided the retrieval rank
+	FusedScore float32 `json:"fused_score,omitempty"`
+	// RerankRank and RerankScore are only set if a reranker ran
+	RerankRank  int      `json:"rerank_rank,omitempty"`
+	RerankScore *float32 `json:"rerank_score,omitempty"`
+	// MmrRank is the position in the selection by maximal marginal relevance
+	MmrRank int `json:"mmr_rank,omitempty"`
+	// Selected is set if the chunk made it into the context
+	Selected bool `json:"selected"`
+}
+
+// Debug explains an answer, it is only returned on request
+type Debug struct {
+	Query string `json:"query"`
+	// SearchQuery is the query the chunks were retrieved for if it differs from Query
+	SearchQuery string `json:"search_query,omitempty"`
+	// Hypothetical and Paraphrases are the expansions of the search query
+	Hypothetical string      `json:"hypothetical,omitempty"`
+	Paraphrases  []string    `json:"paraphrases,omitempty"`
+	Candidates   []Candidate `json:"candidates"`
+}
+
+func candidates(results []*vectordb.SearchResult) []Candidate {
+	candidates := []Candidate{}
+	for i, r := range results {
+		candidates = append(candidates, Candidate{
+			ChunkId:        r.Id,
+			Path:           r.Item.SourceDocument,
+			RetrievalRank:  i + 1,
+			RetrievalScore: r.Score,
+			FusedScore:     r.FusedScore,
+		})
+	}
+	return candidates
+}
+
+func markSelected(candidates []Candidate, selected []*vectordb.SearchResult) {
+	ids := map[string]bool{}
+	for _, r := range selected {
+		ids[r.Id] = true
+	}
+	for i := range candidates {
+		candidates[i].Selected = ids[candidates[i].ChunkId]
+	}
+}
+
+// RerankStage re-orders the retrieved chunks by the score of the reranker and keeps the best TopK, all of them if it is 0
+type RerankStage struct {
+	Reranker Reranker
+	TopK     uint64
+}
+
+func (s *RerankStage) Name() string { return "rerank" }
+
+func (s *RerankStage) Run(ctx context.Context, exchange *Exchange) error {
+	scores, err := s.Reranker.Score(ctx, exchange.searchQuery(), exchange.Results)
+	if err != nil {
+		return err
+	}
+	if len(scores) != len(exchange.Results) {
+		return fmt.Errorf("reranker %s returned %d scores for %d chunks", s.Reranker.Name(), len(scores), len(exchange.Results))
+	}
+
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	// ties keep the order of the retrieval
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+
+	var reranked []*vectordb.SearchResult
+	for rank, i := range order {
+		r := exchange.Results[i]
+		log.Printf("Reranked chunk %s from %s with %s: rank %d -> %d, score %f -> %f",
+			r.Id, r.Item.SourceDocument, s.Reranker.Name(), i+1, rank+1, r.Score, scores[i])
+		exchange.Candidates[i].RerankRank = rank + 1
+		exchange.Candidates[i].RerankScore = &scores[i]
+		if s.TopK == 0 || uint64(len(reranked)) < s.TopK {
+			reranked = append(reranked, r)
+		}
+	}
+	exchange.Results = reranked
+	return nil
+}
+
+// LexicalReranker scores a chunk by the share of the query terms it contains
+type LexicalReranker struct{}
+
+func NewLexicalReranker() *LexicalReranker {
+	return &LexicalReranker{}
+}
+
+func (r *LexicalReranker) Name() string { return RerankLexical }
+
+func (r *LexicalReranker) Score(_ context.Context, query string, results []*vectordb.SearchResult) ([]float32, error) {
+	queryTerms := embedding.TermFrequencies(query)
+
+	scores := make([]float32, len(results))
+	if len(queryTerms) == 0 {
+		return scores, nil
+	}
+	for i, result := range results {
+		chunkTerms := embedding.TermFrequencies(result.Item.Chunk)
+		matched := 0
+		for term := range queryTerms {
+			if chunkTerms[term] > 0 {
+				matched++
+			}
+		}
+		scores[i] = float32(matched) / float32(len(queryTerms))
+	}
+	return scores, nil
+}
+
+const relevancePrompt = `You judge search results.
+Rate how relevant the passage is for answering the question on a scale from 0 (unrelated) to 10 (answers it completely).
+Answer with the number only.
+
+Question: %s
+
+Passage:
+%s`
+
+var relevanceScore = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// LlmReranker asks the model to rate each chunk on its own, a pointwise relevance judgement
+type LlmReranker struct {
+	llm         llms.Model
+	temperature float64
+}
+
+func NewLlmReranker(llm llms.Model, temperature float64) *LlmReranker {
+	return &LlmReranker{
+		llm:         llm,
+		temperature: temperature,
+	}
+}
+
+func (r *LlmReranker) Name() string { return RerankLlm }
+
+func (r *LlmReranker) Score(ctx context.Context, query string, results []*vectordb.SearchResult) ([]float32, error) {
+	scores := make([]float32, len(results))
+	for i, result := range results {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		completion, err := sendToLLM(ctx, r.llm, fmt.Sprintf(relevancePrompt, query, result.Item.Chunk), PromptConfig{temperature: r.temperature})
+		if err != nil {
+			return nil, err
+		}
+		scores[i] = parseRelevance(cleanupAnswer(completion))
+	}
+	return scores, nil
+}
+
+// parseRelevance reads the first number of the answer and scales it to 0..1. Anything else counts as irrelevant.
+func parseRelevance(answer string) float32 {
+	score, err := strconv.ParseFloat(relevanceScore.FindString(answer), 32)
+	if err != nil {
+		log.Printf("Cannot read a relevance score from %q, using 0", answer)
+		return 0
+	}
+	return float32(min(max(score, 0), 10) / 10)
+}
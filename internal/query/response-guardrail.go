@@ -1,16 +1,11 @@
 package query
 
 import (
-	"errors"
 	"log"
 	"regexp"
 	"strings"
-
-	"github.com/koenighotze/rag-demo/config"
-	"github.com/tmc/langchaingo/llms/ollama"
 )
 
-//nolint:unused
 const responseGuardrail = `SYSTEM - Output-Moderation Layer
 You are **OutputSentinel**, an impartial safety classifier.
 You receive the LLM-generated candidate response in the variable
@@ -96,20 +91,3 @@ func cleanupAnswer(rawResponse string) string {
 
 	return strings.TrimSpace(string(re.ReplaceAll([]byte(rawResponse), nil)))
 }
-
-func ApplyResponseGuardrail(guardRailLlm *ollama.LLM, rawResponse string) (sanitized string, err error) {
-	log.Println("Applying response guardrail")
-	completion, err := sendToLLM(guardRailLlm, rawResponse, PromptConfig{temperature: config.Default().Query.OutputTemperature})
-	if err != nil {
-		return "", err
-	}
-	log.Printf("LLM answered with '%s'\n", completion)
-
-	if completion != "safe" {
-		// We could no use the larger model and check the reasons better
-		log.Printf("Unsafe query! Reason %s", completion)
-		return "", errors.New("cannot answer your query. The response might not be good for you")
-	}
-
-	return cleanupAnswer(rawResponse), nil
-}
@@ -0,0 +1,110 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+)
+
+// SearchQuery is a retrieval without generation
+type SearchQuery struct {
+	Query string `json:"query"`
+	// TopK falls back to query.top_k of the configuration if it is 0
+	TopK uint64 `json:"top_k"`
+	// ScoreThreshold is a shortcut for the threshold of the search config
+	ScoreThreshold *float32                  `json:"score_threshold"`
+	SearchConfig   *vectordb.SearchOverrides `json:"search_config"`
+	Filter         *vectordb.Filter          `json:"filter"`
+}
+
+// Validate checks the values a request can set, the configuration is validated on load
+func (q SearchQuery) Validate() error {
+	if strings.TrimSpace(q.Query) == "" {
+		return errors.New("query must not be empty")
+	}
+	if q.ScoreThreshold != nil && (*q.ScoreThreshold < -1 || *q.ScoreThreshold > 1) {
+		return fmt.Errorf("score_threshold must be between -1 and 1, got %g", *q.ScoreThreshold)
+	}
+	return q.SearchConfig.Validate()
+}
+
+// Hit is a retrieved chunk with its metadata
+type Hit struct {
+	Id string `json:"id"`
+	// Score is the similarity to the query, FusedScore the rank fusion score of a hybrid search
+	Score       float32 `json:"score"`
+	FusedScore  float32 `json:"fused_score,omitempty"`
+	Path        string  `json:"path"`
+	Title       string  `json:"title"`
+	Page        int     `json:"page"`
+	EndPage     int     `json:"end_page"`
+	ChunkIndex  int     `json:"chunk_index"`
+	StartOffset int     `json:"start_offset"`
+	EndOffset   int     `json:"end_offset"`
+	Chunk       string  `json:"chunk"`
+	// DocumentType, Tags and IngestedAt are the payload fields a filter can match
+	DocumentType string    `json:"document_type"`
+	Tags         []string  `json:"tags"`
+	IngestedAt   time.Time `json:"ingested_at"`
+}
+
+// Search embeds the query and returns the scored chunks, the LLM is not involved
+func (s *Service) Search(ctx context.Context, store vectordb.VectorStore, search SearchQuery) ([]Hit, error) {
+	log.Printf("Searching for: %s", search.Query)
+
+	item, err := s.embedder.EmbedDocument(ctx, search.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	topK := search.TopK
+	if topK == 0 {
+		topK = s.config.TopK
+	}
+	overrides := search.SearchConfig
+	if search.ScoreThreshold != nil {
+		merged := vectordb.SearchOverrides{}
+		if overrides != nil {
+			merged = *overrides
+		}
+		merged.ScoreThreshold = search.ScoreThreshold
+		overrides = &merged
+	}
+
+	results, err := store.Search(vectordb.SearchRequest{
+		Vector:    item.Embedding,
+		Text:      search.Query,
+		Limit:     topK,
+		Overrides: overrides,
+		Filter:    search.Filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hits := []Hit{}
+	for _, r := range results {
+		hits = append(hits, Hit{
+			Id:           r.Id,
+			Score:        r.Score,
+			FusedScore:   r.FusedScore,
+			Path:         r.Item.SourceDocument,
+			Title:        r.Item.Title,
+			Page:         r.Item.StartPage,
+			EndPage:      r.Item.EndPage,
+			ChunkIndex:   r.Item.ChunkIndex,
+			StartOffset:  r.Item.StartOffset,
+			EndOffset:    r.Item.EndOffset,
+			Chunk:        r.Item.Chunk,
+			DocumentType: r.Item.DocumentType,
+			Tags:         r.Item.Tags,
+			IngestedAt:   r.Item.IngestedAt,
+		})
+	}
+	return hits, nil
+}
@@ -0,0 +1,33 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+)
+
+func TestSearchQueryValidate(t *testing.T) {
+	float32Ptr := func(f float32) *float32 { return &f }
+	float64Ptr := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		name    string
+		search  SearchQuery
+		wantErr bool
+	}{
+		{"query only", SearchQuery{Query: "mainframe"}, false},
+		{"empty query", SearchQuery{Query: "  "}, true},
+		{"score threshold", SearchQuery{Query: "mainframe", ScoreThreshold: float32Ptr(0.5)}, false},
+		{"score threshold above 1", SearchQuery{Query: "mainframe", ScoreThreshold: float32Ptr(1.5)}, true},
+		{"score threshold override below -1", SearchQuery{Query: "mainframe", SearchConfig: &vectordb.SearchOverrides{ScoreThreshold: float32Ptr(-2)}}, true},
+		{"oversampling below 1", SearchQuery{Query: "mainframe", SearchConfig: &vectordb.SearchOverrides{Oversampling: float64Ptr(0.5)}}, true},
+		{"sparse weight", SearchQuery{Query: "mainframe", SearchConfig: &vectordb.SearchOverrides{SparseWeight: float64Ptr(1)}}, false},
+		{"sparse weight above 1", SearchQuery{Query: "mainframe", SearchConfig: &vectordb.SearchOverrides{SparseWeight: float64Ptr(1.1)}}, true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.search.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected an error %t, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
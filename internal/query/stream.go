@@ -0,0 +1,201 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/koenighotze/rag-demo/internal/vectordb"
+	"github.com/tmc/langchaingo/llms"
+)
+
+const (
+	thinkStart = "<think>"
+	thinkEnd   = "</think>"
+)
+
+// TokenSink receives the parts of the answer that passed the response guardrail
+type TokenSink func(text string) error
+
+type StreamSummary struct {
+	Sources   []Source `json:"sources"`
+	Guardrail Verdict  `json:"guardrail"`
+	Debug     *Debug   `json:"debug,omitempty"`
+}
+
+// guardedStream buffers the streamed completion and only hands complete sentences to the sink
+// after the response guardrail accepted them. The thinking block of reasoning models is dropped.
+type guardedStream struct {
+	guardrail *Guardrail
+	sink      TokenSink
+	minChars  int
+	head      strings.Builder
+	headDone  bool
+	pending   strings.Builder
+	answer    strings.Builder
+}
+
+func newGuardedStream(guardrail *Guardrail, sink TokenSink, minChars int) *guardedStream {
+	return &guardedStream{
+		guardrail: guardrail,
+		sink:      sink,
+		minChars:  minChars,
+	}
+}
+
+func (g *guardedStream) write(ctx context.Context, chunk []byte) error {
+	if g.headDone {
+		g.pending.Write(chunk)
+		return g.flush(ctx, false)
+	}
+
+	g.head.Write(chunk)
+	head := strings.TrimLeft(g.head.String(), " \t\r\n")
+	switch {
+	case strings.HasPrefix(head, thinkStart):
+		end := strings.Index(head, thinkEnd)
+		if end < 0 {
+			return nil
+		}
+		log.Printf("Thinking process: %s", strings.ReplaceAll(strings.TrimSpace(head[len(thinkStart):end]), "\n", " "))
+		g.pending.WriteString(strings.TrimLeft(head[end+len(thinkEnd):], " \t\r\n"))
+	case strings.HasPrefix(thinkStart, head):
+		// not enough text yet to decide whether a thinking block starts
+		return nil
+	default:
+		g.pending.WriteString(head)
+	}
+	g.headDone = true
+	g.head.Reset()
+
+	return g.flush(ctx, false)
+}
+
+// flush checks and emits all complete sentences, or everything that is left if final is set
+func (g *guardedStream) flush(ctx context.Context, final bool) error {
+	text := g.pending.String()
+	cut := len(text)
+	if !final {
+		cut = lastSentenceEnd(text)
+		if cut <= 0 || cut < g.minChars {
+			return nil
+		}
+	}
+	if strings.TrimSpace(text[:cut]) == "" {
+		return nil
+	}
+
+	if _, err := g.guardrail.Check(ctx, text[:cut]); err != nil {
+		return err
+	}
+
+	g.pending.Reset()
+	g.pending.WriteString(text[cut:])
+	g.answer.WriteString(text[:cut])
+
+	return g.sink(text[:cut])
+}
+
+func (g *guardedStream) close(ctx context.Context) error {
+	if !g.headDone {
+		g.pending.WriteString(g.head.String())
+		g.headDone = true
+	}
+	return g.flush(ctx, true)
+}
+
+func lastSentenceEnd(text string) int {
+	end := -1
+	for _, terminator := range []string{". ", "! ", "? ", ".\n", "!\n", "?\n", "\n\n"} {
+		if i := strings.LastIndex(text, terminator); i >= 0 && i+len(terminator) > end {
+			end = i + len(terminator)
+		}
+	}
+	return end
+}
+
+// StreamingGenerationStage streams the completion and applies the output guardrail sentence by sentence
+type StreamingGenerationStage struct {
+	Llm             llms.Model
+	Temperature     float64
+	OutputGuardrail *Guardrail
+	// GuardrailMinChars is the least amount of text that is checked by the output guardrail at once
+	GuardrailMinChars int
+	Sink              TokenSink
+}
+
+func (s *StreamingGenerationStage) Name() string { return "streaming-generation" }
+
+func (s *StreamingGenerationStage) Run(ctx context.Context, exchange *Exchange) error {
+	augment(exchange)
+
+	stream := newGuardedStream(s.OutputGuardrail, s.Sink, s.GuardrailMinChars)
+	completion, err := streamToLLM(ctx, s.Llm, exchange.Prompt, PromptConfig{temperature: s.Temperature}, stream.write)
+	if err == nil {
+		err = stream.close(ctx)
+	}
+
+	var blocked *BlockedError
+	if errors.As(err, &blocked) {
+		log.Println("Stopped streaming, the response guardrail blocked the answer")
+		exchange.Verdict = &blocked.Verdict
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	exchange.Completion = completion
+	exchange.Answer = strings.TrimSpace(stream.answer.String())
+	exchange.Verdict = &Verdict{Decision: Allow, Categories: []string{}}
+	return nil
+}
+
+func (s *Service) streamingStage(sink TokenSink) Stage {
+	return &StreamingGenerationStage{
+		Llm:               s.llm,
+		Temperature:       s.config.MainTemperature,
+		OutputGuardrail:   s.outputGuardrail(),
+		GuardrailMinChars: s.config.StreamGuardrailMinChars,
+		Sink:              sink,
+	}
+}
+
+func summarize(exchange *Exchange) *StreamSummary {
+	return &StreamSummary{
+		Sources:   exchange.Sources,
+		Guardrail: *exchange.Verdict,
+	}
+}
+
+func (s *Service) StreamPlainAnswer(ctx context.Context, query string, sink TokenSink) (*StreamSummary, error) {
+	log.Printf("Streaming plain answer: %s", query)
+
+	exchange, err := NewPipeline(
+		&InputGuardrailStage{Guardrail: s.inputGuardrail()},
+		s.streamingStage(sink),
+	).Run(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return summarize(exchange), nil
+}
+
+func (s *Service) StreamAnswerWithRAG(ctx context.Context, store vectordb.VectorStore, options RetrievalOptions, query string, sink TokenSink) (*StreamSummary, error) {
+	log.Printf("Streaming answer for query with vector store: %s", query)
+
+	stages := []Stage{&InputGuardrailStage{Guardrail: s.inputGuardrail()}}
+	stages = append(stages, s.retrievalStages(store, options)...)
+	stages = append(stages, s.streamingStage(sink))
+
+	exchange, err := NewPipeline(stages...).Run(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := summarize(exchange)
+	summary.Debug = debug(exchange, options)
+	return summary, nil
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileStore keeps every session as a json file in a directory
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates the directory if it does not exist
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *FileStore) Load(id string) (*Session, error) {
+	if err := checkId(id); err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Session{Id: id}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(b, &session); err != nil {
+		return nil, err
+	}
+	session.Id = id
+	return &session, nil
+}
+
+func (s *FileStore) Save(session *Session) error {
+	if err := checkId(session.Id); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := s.path(session.Id)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileStore) Delete(id string) error {
+	if err := checkId(id); err != nil {
+		return err
+	}
+
+	err := os.Remove(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
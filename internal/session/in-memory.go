@@ -0,0 +1,61 @@
+package session
+
+import (
+	"slices"
+	"sync"
+)
+
+// InMemoryStore loses all sessions on restart
+type InMemoryStore struct {
+	mu       sync.RWMutex
+	sessions map[string]Session
+}
+
+func NewInMemoryStore() *InMemoryStore {
+	return &InMemoryStore{
+		sessions: map[string]Session{},
+	}
+}
+
+func (s *InMemoryStore) Load(id string) (*Session, error) {
+	if err := checkId(id); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return &Session{Id: id}, nil
+	}
+	// callers must not share the messages with the stored session
+	session.Messages = slices.Clone(session.Messages)
+	return &session, nil
+}
+
+func (s *InMemoryStore) Save(session *Session) error {
+	if err := checkId(session.Id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *session
+	stored.Messages = slices.Clone(session.Messages)
+	s.sessions[session.Id] = stored
+	return nil
+}
+
+func (s *InMemoryStore) Delete(id string) error {
+	if err := checkId(id); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
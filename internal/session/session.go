@@ -0,0 +1,78 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/koenighotze/rag-demo/config"
+)
+
+// the kinds of stores of config.Chat
+const (
+	StoreMemory = "memory"
+	StoreFile   = "file"
+)
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+type Message struct {
+	Role    Role      `json:"role"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time"`
+}
+
+// Session is the history of a conversation. Summary replaces the messages that were dropped to save tokens.
+type Session struct {
+	Id       string    `json:"id"`
+	Summary  string    `json:"summary"`
+	Messages []Message `json:"messages"`
+	Updated  time.Time `json:"updated"`
+}
+
+func (s *Session) Append(role Role, content string) {
+	now := time.Now()
+	s.Messages = append(s.Messages, Message{Role: role, Content: content, Time: now})
+	s.Updated = now
+}
+
+// Store keeps the sessions. Load returns an empty session for an unknown id.
+type Store interface {
+	Load(id string) (*Session, error)
+	Save(session *Session) error
+	Delete(id string) error
+}
+
+// ErrInvalidId is returned for ids that could escape the storage, e.g. path separators for the file store
+var ErrInvalidId = errors.New("invalid session id")
+
+var validId = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func NewId() string {
+	return uuid.New().String()
+}
+
+func checkId(id string) error {
+	if !validId.MatchString(id) {
+		return fmt.Errorf("%w %q: use up to 64 letters, digits, - or _", ErrInvalidId, id)
+	}
+	return nil
+}
+
+func NewStore(chatConfig config.Chat) (Store, error) {
+	switch chatConfig.SessionStore {
+	case StoreFile:
+		return NewFileStore(chatConfig.SessionPath)
+	case StoreMemory, "":
+		return NewInMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown session store %q", chatConfig.SessionStore)
+	}
+}
@@ -0,0 +1,199 @@
+package vectordb
+
+import (
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/koenighotze/rag-demo/internal/embedding"
+	"github.com/qdrant/go-client/qdrant"
+)
+
+// Filter restricts a search to points with matching payload. A point matches if it matches all Must conditions,
+// at least one of the Should conditions if there are any and none of the MustNot conditions.
+// A nil or empty filter matches everything.
+type Filter struct {
+	Must    []Condition `json:"must,omitempty"`
+	Should  []Condition `json:"should,omitempty"`
+	MustNot []Condition `json:"must_not,omitempty"`
+}
+
+// Condition matches if all of its fields that are set match
+type Condition struct {
+	// Sources are document paths, one of them has to match
+	Sources []string `json:"sources,omitempty"`
+	// PathPrefix is a directory, it matches all documents below it
+	PathPrefix string `json:"path_prefix,omitempty"`
+	// DocumentTypes are loader types like pdf or markdown, one of them has to match
+	DocumentTypes []string `json:"document_types,omitempty"`
+	// Tags are the directory names of the document below the corpus root, one of them has to match
+	Tags       []string   `json:"tags,omitempty"`
+	IngestedAt *TimeRange `json:"ingested_at,omitempty"`
+	// Pages matches chunks that share at least one page with the range
+	Pages *PageRange `json:"pages,omitempty"`
+}
+
+// TimeRange includes both bounds, an unset bound is open
+type TimeRange struct {
+	From *time.Time `json:"from,omitempty"`
+	To   *time.Time `json:"to,omitempty"`
+}
+
+// PageRange includes both bounds, a bound of 0 is open
+type PageRange struct {
+	From int `json:"from,omitempty"`
+	To   int `json:"to,omitempty"`
+}
+
+// payload fields that can be filtered on, each one gets a payload index
+const (
+	pathField         = "path"
+	pathPrefixesField = "path_prefixes"
+	documentTypeField = "document_type"
+	tagsField         = "tags"
+	ingestedAtField   = "ingested_at"
+	startPageField    = "start_page"
+	endPageField      = "end_page"
+)
+
+var indexedFields = map[string]qdrant.FieldType{
+	pathField:         qdrant.FieldType_FieldTypeKeyword,
+	pathPrefixesField: qdrant.FieldType_FieldTypeKeyword,
+	documentTypeField: qdrant.FieldType_FieldTypeKeyword,
+	tagsField:         qdrant.FieldType_FieldTypeKeyword,
+	ingestedAtField:   qdrant.FieldType_FieldTypeInteger,
+	startPageField:    qdrant.FieldType_FieldTypeInteger,
+	endPageField:      qdrant.FieldType_FieldTypeInteger,
+}
+
+// pathPrefixes are all directories that contain the path, each one ending with a slash.
+// Qdrant cannot match prefixes, so they are stored with the point and matched as keywords.
+func pathPrefixes(p string) []string {
+	var prefixes []string
+	for dir := path.Dir(path.Clean(filepathToSlash(p))); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		prefixes = append(prefixes, dir+"/")
+	}
+	slices.Reverse(prefixes)
+	return prefixes
+}
+
+func normalizePathPrefix(prefix string) string {
+	return strings.TrimSuffix(path.Clean(filepathToSlash(prefix)), "/") + "/"
+}
+
+func filepathToSlash(p string) string {
+	return strings.ReplaceAll(p, "\\", "/")
+}
+
+func qdrantFilter(filter *Filter) *qdrant.Filter {
+	result := &qdrant.Filter{}
+	if filter == nil {
+		return result
+	}
+
+	for _, c := range filter.Must {
+		result.Must = append(result.Must, qdrantCondition(c))
+	}
+	for _, c := range filter.Should {
+		result.Should = append(result.Should, qdrantCondition(c))
+	}
+	for _, c := range filter.MustNot {
+		result.MustNot = append(result.MustNot, qdrantCondition(c))
+	}
+	return result
+}
+
+// qdrantCondition nests the fields of the condition in a filter, so they all have to match
+func qdrantCondition(c Condition) *qdrant.Condition {
+	var must []*qdrant.Condition
+	if len(c.Sources) > 0 {
+		must = append(must, qdrant.NewMatchKeywords(pathField, c.Sources...))
+	}
+	if c.PathPrefix != "" {
+		must = append(must, qdrant.NewMatchKeyword(pathPrefixesField, normalizePathPrefix(c.PathPrefix)))
+	}
+	if len(c.DocumentTypes) > 0 {
+		must = append(must, qdrant.NewMatchKeywords(documentTypeField, c.DocumentTypes...))
+	}
+	if len(c.Tags) > 0 {
+		must = append(must, qdrant.NewMatchKeywords(tagsField, c.Tags...))
+	}
+	if c.IngestedAt != nil {
+		r := &qdrant.Range{}
+		if c.IngestedAt.From != nil {
+			r.Gte = qdrant.PtrOf(float64(c.IngestedAt.From.Unix()))
+		}
+		if c.IngestedAt.To != nil {
+			r.Lte = qdrant.PtrOf(float64(c.IngestedAt.To.Unix()))
+		}
+		must = append(must, qdrant.NewRange(ingestedAtField, r))
+	}
+	if c.Pages != nil {
+		if c.Pages.To > 0 {
+			must = append(must, qdrant.NewRange(startPageField, &qdrant.Range{Lte: qdrant.PtrOf(float64(c.Pages.To))}))
+		}
+		if c.Pages.From > 0 {
+			must = append(must, qdrant.NewRange(endPageField, &qdrant.Range{Gte: qdrant.PtrOf(float64(c.Pages.From))}))
+		}
+	}
+	return qdrant.NewFilterAsCondition(&qdrant.Filter{Must: must})
+}
+
+// matches applies the filter with the same semantics as qdrantFilter
+func matches(filter *Filter, item embedding.KnowledgeItem) bool {
+	if filter == nil {
+		return true
+	}
+
+	for _, c := range filter.Must {
+		if !matchesCondition(c, item) {
+			return false
+		}
+	}
+	for _, c := range filter.MustNot {
+		if matchesCondition(c, item) {
+			return false
+		}
+	}
+	if len(filter.Should) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(filter.Should, func(c Condition) bool {
+		return matchesCondition(c, item)
+	})
+}
+
+func matchesCondition(c Condition, item embedding.KnowledgeItem) bool {
+	if len(c.Sources) > 0 && !slices.Contains(c.Sources, item.SourceDocument) {
+		return false
+	}
+	if c.PathPrefix != "" && !slices.Contains(pathPrefixes(item.SourceDocument), normalizePathPrefix(c.PathPrefix)) {
+		return false
+	}
+	if len(c.DocumentTypes) > 0 && !slices.Contains(c.DocumentTypes, item.DocumentType) {
+		return false
+	}
+	if len(c.Tags) > 0 && !slices.ContainsFunc(c.Tags, func(tag string) bool { return slices.Contains(item.Tags, tag) }) {
+		return false
+	}
+	if c.IngestedAt != nil {
+		// qdrant compares unix seconds
+		ingestedAt := item.IngestedAt.Unix()
+		if c.IngestedAt.From != nil && ingestedAt < c.IngestedAt.From.Unix() {
+			return false
+		}
+		if c.IngestedAt.To != nil && ingestedAt > c.IngestedAt.To.Unix() {
+			return false
+		}
+	}
+	if c.Pages != nil {
+		if c.Pages.To > 0 && item.StartPage > c.Pages.To {
+			return false
+		}
+		if c.Pages.From > 0 && item.EndPage < c.Pages.From {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package vectordb
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/koenighotze/rag-demo/internal/embedding"
+	"github.com/qdrant/go-client/qdrant"
+)
+
+// evaluate applies a qdrant filter to a payload the way qdrant does: a keyword matches a list if one of its
+// values matches, conditions on missing fields never match
+func evaluate(t *testing.T, filter *qdrant.Filter, payload map[string]*qdrant.Value) bool {
+	t.Helper()
+	for _, c := range filter.GetMust() {
+		if !evaluateCondition(t, c, payload) {
+			return false
+		}
+	}
+	for _, c := range filter.GetMustNot() {
+		if evaluateCondition(t, c, payload) {
+			return false
+		}
+	}
+	if len(filter.GetShould()) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(filter.GetShould(), func(c *qdrant.Condition) bool {
+		return evaluateCondition(t, c, payload)
+	})
+}
+
+func evaluateCondition(t *testing.T, condition *qdrant.Condition, payload map[string]*qdrant.Value) bool {
+	t.Helper()
+	if nested := condition.GetFilter(); nested != nil {
+		return evaluate(t, nested, payload)
+	}
+
+	field := condition.GetField()
+	if field == nil {
+		t.Fatalf("unsupported condition %v", condition)
+	}
+	value, ok := payload[field.GetKey()]
+	if !ok {
+		return false
+	}
+
+	if match := field.GetMatch(); match != nil {
+		wanted := match.GetKeywords().GetStrings()
+		if keyword := match.GetKeyword(); keyword != "" {
+			wanted = []string{keyword}
+		}
+		values := []*qdrant.Value{value}
+		if list := value.GetListValue(); list != nil {
+			values = list.GetValues()
+		}
+		return slices.ContainsFunc(values, func(v *qdrant.Value) bool {
+			return slices.Contains(wanted, v.GetStringValue())
+		})
+	}
+
+	if r := field.GetRange(); r != nil {
+		number := float64(value.GetIntegerValue())
+		return (r.Gte == nil || number >= r.GetGte()) && (r.Lte == nil || number <= r.GetLte())
+	}
+
+	t.Fatalf("unsupported field condition %v", field)
+	return false
+}
+
+func at(day int) *time.Time {
+	t := time.Date(2025, time.March, day, 12, 0, 0, 0, time.UTC)
+	return &t
+}
+
+// filterItems returns new items for every test, so tests may change them
+func filterItems() []*embedding.KnowledgeItem {
+	return []*embedding.KnowledgeItem{
+		{Id: "0b5f4c0e-0000-4000-8000-000000000001", SourceDocument: "corpus/projects/mainframe.pdf", DocumentType: "pdf", Tags: []string{"projects"}, IngestedAt: *at(1), StartPage: 3, EndPage: 4},
+		{Id: "0b5f4c0e-0000-4000-8000-000000000002", SourceDocument: "corpus/projects/cloud/migration.md", DocumentType: "markdown", Tags: []string{"projects", "cloud"}, IngestedAt: *at(10), StartPage: 0, EndPage: 0},
+		{Id: "0b5f4c0e-0000-4000-8000-000000000003", SourceDocument: "corpus/notes.txt", DocumentType: "text", IngestedAt: *at(20)},
+		{Id: "0b5f4c0e-0000-4000-8000-000000000004", SourceDocument: "corpus/projects-archive/old.pdf", DocumentType: "pdf", Tags: []string{"projects-archive"}, IngestedAt: *at(5), StartPage: 10, EndPage: 12},
+	}
+}
+
+func TestFilterParity(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *Filter
+		// want are the indexes of the matching items in filterItems
+		want []int
+	}{
+		{"nil filter", nil, []int{0, 1, 2, 3}},
+		{"empty filter", &Filter{}, []int{0, 1, 2, 3}},
+		{"source", &Filter{Must: []Condition{{Sources: []string{"corpus/notes.txt"}}}}, []int{2}},
+		{"path prefix without trailing slash", &Filter{Must: []Condition{{PathPrefix: "corpus/projects"}}}, []int{0, 1}},
+		{"nested path prefix", &Filter{Must: []Condition{{PathPrefix: "corpus/projects/cloud/"}}}, []int{1}},
+		{"document types", &Filter{Must: []Condition{{DocumentTypes: []string{"pdf", "text"}}}}, []int{0, 2, 3}},
+		{"one of the tags", &Filter{Must: []Condition{{Tags: []string{"cloud", "projects-archive"}}}}, []int{1, 3}},
+		{"ingested from", &Filter{Must: []Condition{{IngestedAt: &TimeRange{From: at(5)}}}}, []int{1, 2, 3}},
+		{"ingested between", &Filter{Must: []Condition{{IngestedAt: &TimeRange{From: at(2), To: at(10)}}}}, []int{1, 3}},
+		{"pages overlapping the range", &Filter{Must: []Condition{{Pages: &PageRange{From: 4, To: 10}}}}, []int{0, 3}},
+		{"pages up to", &Filter{Must: []Condition{{Pages: &PageRange{To: 2}}}}, []int{1, 2}},
+		{"all fields of a condition", &Filter{Must: []Condition{{DocumentTypes: []string{"pdf"}, Tags: []string{"projects"}}}}, []int{0}},
+		{"should", &Filter{Should: []Condition{{DocumentTypes: []string{"text"}}, {Tags: []string{"cloud"}}}}, []int{1, 2}},
+		{"must not", &Filter{MustNot: []Condition{{PathPrefix: "corpus/projects"}}}, []int{2, 3}},
+		{"must with must not", &Filter{
+			Must:    []Condition{{DocumentTypes: []string{"pdf"}}},
+			MustNot: []Condition{{IngestedAt: &TimeRange{To: at(1)}}},
+		}, []int{3}},
+	}
+
+	items := filterItems()
+	points := createPointsFromEmbeddings(items, true)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qdrantFilter := qdrantFilter(tt.filter)
+			var inMemory, inQdrant []int
+			for i, item := range items {
+				if matches(tt.filter, *item) {
+					inMemory = append(inMemory, i)
+				}
+				if evaluate(t, qdrantFilter, points[i].Payload) {
+					inQdrant = append(inQdrant, i)
+				}
+			}
+
+			if fmt.Sprint(inMemory) != fmt.Sprint(tt.want) {
+				t.Errorf("in-memory filter matched %v, expected %v", inMemory, tt.want)
+			}
+			if fmt.Sprint(inQdrant) != fmt.Sprint(tt.want) {
+				t.Errorf("qdrant filter matched %v, expected %v", inQdrant, tt.want)
+			}
+		})
+	}
+}
+
+func TestInMemorySearchFilters(t *testing.T) {
+	store := NewInMemoryVectorStore(nil)
+	items := filterItems()
+	for _, item := range items {
+		item.Embedding = []float32{1, 0}
+	}
+	if err := store.AddPointsToCollection(items); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := store.Search(SearchRequest{
+		Vector: []float32{1, 0},
+		Limit:  10,
+		Filter: &Filter{Must: []Condition{{DocumentTypes: []string{"pdf"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected the 2 pdf chunks, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Item.DocumentType != "pdf" {
+			t.Errorf("expected only pdf chunks, got %s from %s", r.Item.DocumentType, r.Item.SourceDocument)
+		}
+	}
+}
+
+func TestPathPrefixes(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"corpus/projects/cloud/migration.md", []string{"corpus/", "corpus/projects/", "corpus/projects/cloud/"}},
+		{"notes.txt", nil},
+		{`corpus\windows\file.txt`, []string{"corpus/", "corpus/windows/"}},
+	}
+
+	for _, tt := range tests {
+		if got := pathPrefixes(tt.path); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("pathPrefixes(%q) = %q, expected %q", tt.path, got, tt.want)
+		}
+	}
+}
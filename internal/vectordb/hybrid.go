@@ -0,0 +1,98 @@
+package vectordb
+
+import (
+	"log"
+	"sort"
+
+	"github.com/koenighotze/rag-demo/internal/embedding"
+)
+
+const (
+	// qdrant stores the unnamed vector of a collection under the empty name
+	denseVectorName  = ""
+	sparseVectorName = "bm25"
+	// rrfK dampens the difference between the top ranks, 60 is the value of the original paper
+	rrfK = 60
+)
+
+// sparseQuery encodes the query text if the search is hybrid and there is something to match
+func sparseQuery(vocabulary *embedding.Vocabulary, request SearchRequest, searchConfig QdrantSearchConfig) (embedding.SparseVector, bool) {
+	if !searchConfig.Hybrid {
+		return embedding.SparseVector{}, false
+	}
+	if vocabulary == nil || request.Text == "" {
+		log.Println("Hybrid search needs the query text and a vocabulary, using the dense results only")
+		return embedding.SparseVector{}, false
+	}
+
+	sparse := vocabulary.EncodeQuery(request.Text)
+	if sparse.IsEmpty() {
+		log.Println("No query term is part of the vocabulary, using the dense results only")
+		return sparse, false
+	}
+	return sparse, true
+}
+
+type weightedRanking struct {
+	results []*SearchResult
+	weight  float64
+	// dense rankings carry cosine similarities, the fused result keeps the best of them as its score
+	dense bool
+}
+
+// fuse merges the dense and the sparse ranking of a hybrid search. The score threshold only applies to the
+// dense ranking, chunks found by keywords alone are kept with a score of 0.
+func fuse(dense []*SearchResult, sparse []*SearchResult, sparseWeight float64, limit uint64) []*SearchResult {
+	return fuseRankings(limit, weightedRanking{dense, 1 - sparseWeight, true}, weightedRanking{sparse, sparseWeight, false})
+}
+
+// Fuse merges the results of several dense or hybrid searches with the same weight, e.g. for paraphrases of a query
+func Fuse(rankings [][]*SearchResult, limit uint64) []*SearchResult {
+	var weighted []weightedRanking
+	for _, results := range rankings {
+		weighted = append(weighted, weightedRanking{results, 1, true})
+	}
+	return fuseRankings(limit, weighted...)
+}
+
+// fuseRankings merges the rankings by weighted reciprocal rank fusion. The fused score of a result is the sum of
+// weight / (rrfK + rank) over the rankings it appears in, so it is only comparable within one search.
+func fuseRankings(limit uint64, rankings ...weightedRanking) []*SearchResult {
+	fused := map[string]*SearchResult{}
+	scores := map[string]float64{}
+	scored := map[string]bool{}
+	for _, ranking := range rankings {
+		for rank, r := range ranking.results {
+			result, ok := fused[r.Id]
+			if !ok {
+				result = &SearchResult{Id: r.Id, Item: r.Item}
+				fused[r.Id] = result
+			}
+			scores[r.Id] += ranking.weight / float64(rrfK+rank+1)
+			if ranking.dense && (!scored[r.Id] || r.Score > result.Score) {
+				result.Score = r.Score
+				scored[r.Id] = true
+			}
+		}
+	}
+
+	var result []*SearchResult
+	for id, r := range fused {
+		r.FusedScore = float32(scores[id])
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].FusedScore != result[j].FusedScore {
+			return result[i].FusedScore > result[j].FusedScore
+		}
+		return result[i].Id < result[j].Id
+	})
+
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	if uint64(len(result)) > limit {
+		result = result[:limit]
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package vectordb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func ranking(ids ...string) []*SearchResult {
+	var results []*SearchResult
+	for _, id := range ids {
+		results = append(results, &SearchResult{Id: id})
+	}
+	return results
+}
+
+func TestFuseRankings(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    uint64
+		rankings []weightedRanking
+		want     []string
+	}{
+		{"single ranking keeps its order", 10, []weightedRanking{{ranking("a", "b", "c"), 1, true}}, []string{"a", "b", "c"}},
+		{"results of both rankings come first", 10, []weightedRanking{
+			{ranking("a", "b", "c"), 1, true},
+			{ranking("c", "b", "d"), 1, true},
+		}, []string{"c", "b", "a", "d"}},
+		{"equal scores are ordered by id", 10, []weightedRanking{
+			{ranking("b", "a"), 1, true},
+			{ranking("a", "b"), 1, true},
+		}, []string{"a", "b"}},
+		{"the heavier ranking wins", 10, []weightedRanking{
+			{ranking("a", "b"), 0.2, true},
+			{ranking("b", "a"), 0.8, true},
+		}, []string{"b", "a"}},
+		{"zero weight ranking only adds results", 10, []weightedRanking{
+			{ranking("a", "b"), 1, true},
+			{ranking("c", "b"), 0, true},
+		}, []string{"a", "b", "c"}},
+		{"limit", 2, []weightedRanking{{ranking("a", "b", "c"), 1, true}}, []string{"a", "b"}},
+		{"no limit uses the qdrant default", 0, []weightedRanking{
+			{ranking("a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"), 1, true},
+		}, []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}},
+		{"no rankings", 10, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ids(fuseRankings(tt.limit, tt.rankings...))
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("fused %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuseScores(t *testing.T) {
+	dense := []*SearchResult{{Id: "a", Score: 0.9}, {Id: "b", Score: 0.4}}
+	keywords := []*SearchResult{{Id: "b", Score: 7.5}, {Id: "c", Score: 3.2}}
+	fused := fuse(dense, keywords, 0.25, 10)
+
+	want := map[string]struct{ score, fused float32 }{
+		"a": {0.9, float32(0.75 / (rrfK + 1))},
+		"b": {0.4, float32(0.75/(rrfK+2) + 0.25/(rrfK+1))},
+		// bm25 scores are not similarities, chunks only found by keywords have none
+		"c": {0, float32(0.25 / (rrfK + 2))},
+	}
+	if len(fused) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(fused))
+	}
+	for _, r := range fused {
+		if r.Score != want[r.Id].score || r.FusedScore != want[r.Id].fused {
+			t.Errorf("%s scores %v fused %v, expected %v fused %v", r.Id, r.Score, r.FusedScore, want[r.Id].score, want[r.Id].fused)
+		}
+	}
+}
+
+func TestFuseKeepsTheBestSimilarity(t *testing.T) {
+	fused := Fuse([][]*SearchResult{
+		{{Id: "a", Score: -0.2}},
+		{{Id: "b", Score: 0.8}, {Id: "a", Score: -0.1}},
+	}, 10)
+
+	for _, r := range fused {
+		if want := map[string]float32{"a": -0.1, "b": 0.8}[r.Id]; r.Score != want {
+			t.Errorf("%s scores %v, expected the best similarity %v", r.Id, r.Score, want)
+		}
+	}
+}
+
+func TestFuseWeighsParaphrasesEqually(t *testing.T) {
+	got := ids(Fuse([][]*SearchResult{ranking("a", "b"), ranking("b", "a"), ranking("b", "c")}, 10))
+	if want := []string{"b", "a", "c"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("fused %v, expected %v", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+package vectordb
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/koenighotze/rag-demo/internal/embedding"
+)
+
+// Qdrant returns at most this many points if no limit is given
+const defaultSearchLimit = 10
+
+type InMemoryVectorStore struct {
+	mu           sync.RWMutex
+	points       map[string]embedding.KnowledgeItem
+	searchConfig QdrantSearchConfig
+	vocabulary   *embedding.Vocabulary
+}
+
+// NewInMemoryVectorStore creates an empty store, vocabulary encodes queries for hybrid search
+func NewInMemoryVectorStore(vocabulary *embedding.Vocabulary) *InMemoryVectorStore {
+	return &InMemoryVectorStore{
+		points:       map[string]embedding.KnowledgeItem{},
+		searchConfig: defaultQdrantSearchConfig(),
+		vocabulary:   vocabulary,
+	}
+}
+
+func (s *InMemoryVectorStore) AddPointsToCollection(items []*embedding.KnowledgeItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, item := range items {
+		id := pointId(item)
+		stored := *item
+		stored.Id = id
+		s.points[id] = stored
+	}
+	return nil
+}
+
+func (s *InMemoryVectorStore) ExecuteSearch(search []float32, limit uint64) ([]*SearchResult, error) {
+	return s.Search(SearchRequest{Vector: search, Limit: limit})
+}
+
+func (s *InMemoryVectorStore) Search(request SearchRequest) ([]*SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// the search is always exact, only the threshold and the hybrid settings apply
+	searchConfig := s.searchConfig.With(request.Overrides)
+
+	dense := s.rank(request, func(item embedding.KnowledgeItem) (float32, bool) {
+		score := CosineSimilarity(request.Vector, item.Embedding)
+		return score, score >= searchConfig.ScoreThreshold
+	})
+
+	sparse, ok := sparseQuery(s.vocabulary, request, searchConfig)
+	if !ok {
+		return dense, nil
+	}
+	keywords := s.rank(request, func(item embedding.KnowledgeItem) (float32, bool) {
+		score := item.Sparse.Dot(sparse)
+		return score, score > 0
+	})
+	return fuse(dense, keywords, searchConfig.SparseWeight, request.Limit), nil
+}
+
+// rank returns the best matching points that pass score, the way qdrant searches a single vector
+func (s *InMemoryVectorStore) rank(request SearchRequest, score func(item embedding.KnowledgeItem) (float32, bool)) []*SearchResult {
+	var result []*SearchResult
+	for id, item := range s.points {
+		if !matches(request.Filter, item) {
+			continue
+		}
+		itemScore, ok := score(item)
+		if !ok {
+			continue
+		}
+		result = append(result, &SearchResult{
+			Id:    id,
+			Score: itemScore,
+			Item:  item,
+		})
+	}
+
+	// equal scores are ordered by id, so the result does not depend on the iteration order of the map
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Id < result[j].Id
+	})
+	limit := request.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	if uint64(len(result)) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+func (s *InMemoryVectorStore) DeletePoints(ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		delete(s.points, id)
+	}
+	return nil
+}
+
+func (s *InMemoryVectorStore) CountPoints() (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return uint64(len(s.points)), nil
+}
+
+func (s *InMemoryVectorStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.points = map[string]embedding.KnowledgeItem{}
+}
+
+// CosineSimilarity is 0 if the vectors differ in size or one of them is zero
+func CosineSimilarity(a, b []float32) float32 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
@@ -0,0 +1,167 @@
+package vectordb
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/koenighotze/rag-demo/internal/embedding"
+)
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float32
+	}{
+		{"same direction", []float32{1, 2}, []float32{2, 4}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 3}, 0},
+		{"opposite", []float32{1, 1}, []float32{-1, -1}, -1},
+		{"45 degrees", []float32{1, 0}, []float32{1, 1}, float32(1 / math.Sqrt2)},
+		{"zero vector", []float32{0, 0}, []float32{1, 1}, 0},
+		{"dimension mismatch", []float32{1, 0}, []float32{1, 0, 0}, 0},
+		{"empty", nil, nil, 0},
+	}
+
+	for _, tt := range tests {
+		if got := CosineSimilarity(tt.a, tt.b); math.Abs(float64(got-tt.want)) > 1e-6 {
+			t.Errorf("%s: got %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func ids(results []*SearchResult) []string {
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.Id)
+	}
+	return ids
+}
+
+// storeWith adds a point with the vector for every id
+func storeWith(t *testing.T, vectors map[string][]float32) *InMemoryVectorStore {
+	t.Helper()
+	store := NewInMemoryVectorStore(nil)
+	var items []*embedding.KnowledgeItem
+	for id, vector := range vectors {
+		items = append(items, &embedding.KnowledgeItem{Id: id, Embedding: vector, Chunk: id})
+	}
+	if err := store.AddPointsToCollection(items); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestInMemorySearch(t *testing.T) {
+	threshold := func(f float32) *SearchOverrides { return &SearchOverrides{ScoreThreshold: &f} }
+
+	tests := []struct {
+		name    string
+		vectors map[string][]float32
+		request SearchRequest
+		want    []string
+	}{
+		{"ordered by cosine similarity", map[string][]float32{
+			"far":   {0.5, 1},
+			"exact": {1, 0},
+			"near":  {1, 0.2},
+		}, SearchRequest{Vector: []float32{1, 0}}, []string{"exact", "near", "far"}},
+		{"the length of the vectors does not matter", map[string][]float32{
+			"long":  {10, 1},
+			"short": {1, 0},
+		}, SearchRequest{Vector: []float32{1, 0}}, []string{"short", "long"}},
+		{"ties are ordered by id", map[string][]float32{
+			"c": {1, 0},
+			"a": {2, 0},
+			"b": {3, 0},
+		}, SearchRequest{Vector: []float32{1, 0}}, []string{"a", "b", "c"}},
+		{"below the default threshold", map[string][]float32{
+			"match":      {1, 0},
+			"orthogonal": {0, 1},
+		}, SearchRequest{Vector: []float32{1, 0}}, []string{"match"}},
+		{"zero vectors never match", map[string][]float32{
+			"zero":  {0, 0},
+			"match": {1, 0},
+		}, SearchRequest{Vector: []float32{1, 0}}, []string{"match"}},
+		{"zero query matches nothing", map[string][]float32{
+			"a": {1, 0},
+		}, SearchRequest{Vector: []float32{0, 0}}, nil},
+		{"other dimensions never match", map[string][]float32{
+			"other": {1, 0, 0},
+			"match": {1, 0},
+		}, SearchRequest{Vector: []float32{1, 0}}, []string{"match"}},
+		{"limit", map[string][]float32{
+			"a": {1, 0},
+			"b": {1, 0.1},
+			"c": {1, 0.2},
+		}, SearchRequest{Vector: []float32{1, 0}, Limit: 2}, []string{"a", "b"}},
+		{"threshold override", map[string][]float32{
+			"exact": {1, 0},
+			"near":  {1, 0.2},
+			"far":   {0.5, 1},
+		}, SearchRequest{Vector: []float32{1, 0}, Overrides: threshold(0.9)}, []string{"exact", "near"}},
+		{"negative threshold keeps opposite vectors", map[string][]float32{
+			"exact":    {1, 0},
+			"opposite": {-1, 0},
+		}, SearchRequest{Vector: []float32{1, 0}, Overrides: threshold(-1)}, []string{"exact", "opposite"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := storeWith(t, tt.vectors).Search(tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(results); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("found %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInMemorySearchDefaultLimit(t *testing.T) {
+	vectors := map[string][]float32{}
+	for i := range defaultSearchLimit + 5 {
+		vectors[fmt.Sprintf("%02d", i)] = []float32{1, float32(i) / 100}
+	}
+
+	results, err := storeWith(t, vectors).ExecuteSearch([]float32{1, 0}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != defaultSearchLimit {
+		t.Fatalf("expected %d results, got %d", defaultSearchLimit, len(results))
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i-1].Score < results[i].Score {
+			t.Errorf("result %d scores %v, more than result %d with %v", i, results[i].Score, i-1, results[i-1].Score)
+		}
+	}
+}
+
+func TestInMemoryStore(t *testing.T) {
+	store := storeWith(t, map[string][]float32{"a": {1, 0}, "b": {0, 1}})
+
+	if err := store.AddPointsToCollection([]*embedding.KnowledgeItem{{Id: "a", Embedding: []float32{0, 1}}}); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := store.CountPoints(); count != 2 {
+		t.Errorf("upserting an existing id must replace the point, got %d points", count)
+	}
+
+	if err := store.DeletePoints([]string{"b", "unknown"}); err != nil {
+		t.Fatal(err)
+	}
+	results, err := store.ExecuteSearch([]float32{0, 1}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(results); fmt.Sprint(got) != "[a]" {
+		t.Errorf("expected the replaced point a only, got %v", got)
+	}
+
+	store.Close()
+	if count, _ := store.CountPoints(); count != 0 {
+		t.Errorf("expected an empty store after close, got %d points", count)
+	}
+}
@@ -2,24 +2,25 @@ package vectordb
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
-	"sync"
 
 	"github.com/koenighotze/rag-demo/config"
+	"github.com/koenighotze/rag-demo/internal/embedding"
 	"github.com/qdrant/go-client/qdrant"
 )
 
-var (
-	once    sync.Once
-	client  *qdrant.Client
-	initErr error
-)
+func executeSearch(client *qdrant.Client, collection string, request SearchRequest, searchConfig QdrantSearchConfig) ([]*qdrant.ScoredPoint, error) {
+	limit := request.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
 
-func executeSearch(client *qdrant.Client, search []float32, searchConfig QdrantSearchConfig) ([]*qdrant.ScoredPoint, error) {
 	searchResult, err := client.Query(context.Background(), &qdrant.QueryPoints{
-		CollectionName: "rag",
-		Query:          qdrant.NewQuery(search...),
-		Filter:         &qdrant.Filter{},
+		CollectionName: collection,
+		Query:          qdrant.NewQuery(request.Vector...),
+		Filter:         qdrantFilter(request.Filter),
 		Params: &qdrant.SearchParams{
 			/*
 				Exact — turn off approximation and do an exact scan
@@ -40,7 +41,7 @@ func executeSearch(client *qdrant.Client, search []float32, searchConfig QdrantS
 				Trade-off: speed & memory vs. precision.
 				Common pattern: Use quantized vectors for the coarse search, then rescore a small top-K with full precision for quality.
 			*/
-			// Quantization: &qdrant.QuantizationSearchParams{},
+			Quantization: quantizationParams(searchConfig),
 
 			/*
 				IndexedOnly — search only in indexed (or small) segments
@@ -60,23 +61,73 @@ func executeSearch(client *qdrant.Client, search []float32, searchConfig QdrantS
 
 				When to tweak: If results feel “close but not perfect,” try increasing ef. If latency is too high, lower it.
 			*/
-			HnswEf: qdrant.PtrOf(searchConfig.BeamSize),
+			HnswEf: beamSize(searchConfig),
 		},
 		ScoreThreshold: qdrant.PtrOf(searchConfig.ScoreThreshold),
+		Limit:          qdrant.PtrOf(limit),
 		WithPayload:    qdrant.NewWithPayloadEnable(true),
+		WithVectors:    qdrant.NewWithVectorsEnable(request.WithVectors),
 	})
 
 	return searchResult, err
 }
 
-func ensureCollection(ctx context.Context, c *qdrant.Client, name string, truncate bool) error {
+// executeSparseSearch ranks the points by bm25. The scores are not comparable to cosine similarity, so there is no threshold.
+func executeSparseSearch(client *qdrant.Client, collection string, sparse embedding.SparseVector, request SearchRequest) ([]*qdrant.ScoredPoint, error) {
+	limit := request.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+
+	return client.Query(context.Background(), &qdrant.QueryPoints{
+		CollectionName: collection,
+		Query:          qdrant.NewQuerySparse(sparse.Indices, sparse.Values),
+		Using:          qdrant.PtrOf(sparseVectorName),
+		Filter:         qdrantFilter(request.Filter),
+		Limit:          qdrant.PtrOf(limit),
+		WithPayload:    qdrant.NewWithPayloadEnable(true),
+		WithVectors:    qdrant.NewWithVectorsEnable(request.WithVectors),
+	})
+}
+
+// ErrCollectionMismatch is returned if an existing collection does not fit the vectors of the embedding model
+var ErrCollectionMismatch = errors.New("collection does not match the embedding model")
+
+// the distance metric of every collection
+const collectionDistance = qdrant.Distance_Cosine
+
+// quantizationParams is nil unless rescore or oversampling are set, qdrant picks its defaults then
+func quantizationParams(searchConfig QdrantSearchConfig) *qdrant.QuantizationSearchParams {
+	if searchConfig.Rescore == nil && searchConfig.Oversampling <= 0 {
+		return nil
+	}
+	params := &qdrant.QuantizationSearchParams{
+		Rescore: searchConfig.Rescore,
+	}
+	if searchConfig.Oversampling > 0 {
+		params.Oversampling = qdrant.PtrOf(searchConfig.Oversampling)
+	}
+	return params
+}
+
+func beamSize(searchConfig QdrantSearchConfig) *uint64 {
+	if searchConfig.BeamSize == 0 {
+		return nil
+	}
+	return qdrant.PtrOf(searchConfig.BeamSize)
+}
+
+// ensureCollection reports whether the collection was created and whether it has the sparse vector for hybrid search.
+// Collections created before hybrid search have none, they are only rejected if hybrid search is enabled.
+func ensureCollection(ctx context.Context, c *qdrant.Client, name string, dimension uint64, truncate bool, hybrid bool) (created bool, sparse bool, err error) {
 	exists, err := c.CollectionExists(ctx, name)
 	if err != nil {
-		return err
+		return false, false, err
 	}
 	if exists {
 		if !truncate {
-			return nil
+			sparse, err := checkCollection(ctx, c, name, dimension, hybrid)
+			return false, sparse, err
 		}
 
 		log.Println("Truncating collection", name)
@@ -85,7 +136,7 @@ func ensureCollection(ctx context.Context, c *qdrant.Client, name string, trunca
 	}
 
 	log.Println("Creating collection: ", name)
-	return c.CreateCollection(context.Background(), &qdrant.CreateCollection{
+	err = c.CreateCollection(context.Background(), &qdrant.CreateCollection{
 		CollectionName: name,
 		VectorsConfig: qdrant.NewVectorsConfig(&qdrant.VectorParams{
 			/*
@@ -94,53 +145,105 @@ func ensureCollection(ctx context.Context, c *qdrant.Client, name string, trunca
 				For example: [0.1, -0.5, 0.8, 0.3] - that's 4 numbers
 				Important: This must match the actual size of embeddings your model produces
 			*/
-			Size: 768,
+			Size: dimension,
 			/*
 				This determines how Qdrant calculates similarity between vectors
 				Cosine similarity measures the angle between vectors, not their length
 				Perfect for text embeddings because it focuses on meaning/direction rather than magnitude
 			*/
-			Distance: qdrant.Distance_Cosine,
+			Distance: collectionDistance,
+		}),
+		SparseVectorsConfig: qdrant.NewSparseVectorsConfig(map[string]*qdrant.SparseVectorParams{
+			sparseVectorName: {},
 		}),
 	})
-}
-
-func defaultClient() *qdrant.Client {
-	client, err := newClient(false, config.QdrantConfig())
-
 	if err != nil {
-		log.Panic(err)
+		return false, false, err
 	}
-
-	return client
+	return true, true, createPayloadIndexes(ctx, c, name)
 }
 
-func newClient(truncate bool, config config.Qdrant) (*qdrant.Client, error) {
-	once.Do(func() {
-		client, err := qdrant.NewClient(&qdrant.Config{
-			Host: config.Host,
-			Port: config.Port,
+// createPayloadIndexes indexes the fields that searches filter on, without an index qdrant scans every point
+func createPayloadIndexes(ctx context.Context, c *qdrant.Client, name string) error {
+	for field, fieldType := range indexedFields {
+		_, err := c.CreateFieldIndex(ctx, &qdrant.CreateFieldIndexCollection{
+			CollectionName: name,
+			Wait:           qdrant.PtrOf(true),
+			FieldName:      field,
+			FieldType:      qdrant.PtrOf(fieldType),
 		})
-
 		if err != nil {
-			initErr = err
-			return
+			return fmt.Errorf("cannot create payload index for %s: %w", field, err)
 		}
+	}
+	return nil
+}
 
-		if err := ensureCollection(context.Background(), client, config.CollectionName, truncate); err != nil {
-			initErr = err
-			return
+func checkCollection(ctx context.Context, c *qdrant.Client, name string, dimension uint64, hybrid bool) (bool, error) {
+	info, err := c.GetCollectionInfo(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	params := info.GetConfig().GetParams().GetVectorsConfig().GetParams()
+	if params == nil {
+		return false, fmt.Errorf("%w: %s uses named vectors, expected a single vector of size %d", ErrCollectionMismatch, name, dimension)
+	}
+	if params.GetSize() != dimension || params.GetDistance() != collectionDistance {
+		return false, fmt.Errorf("%w: %s stores vectors of size %d with %s distance, the embedding model needs size %d with %s distance. Re-index with -rebuild",
+			ErrCollectionMismatch, name, params.GetSize(), params.GetDistance(), dimension, collectionDistance)
+	}
+	if _, ok := info.GetConfig().GetParams().GetSparseVectorsConfig().GetMap()[sparseVectorName]; !ok {
+		if hybrid {
+			return false, fmt.Errorf("%w: %s has no sparse vector %s for hybrid search. Re-index with -rebuild", ErrCollectionMismatch, name, sparseVectorName)
 		}
-	})
+		log.Printf("Collection %s has no sparse vector %s, hybrid searches use the dense results only. Re-index with -rebuild to enable them", name, sparseVectorName)
+		return false, nil
+	}
+	return true, nil
+}
 
-	return client, initErr
+// Connection is a connection to qdrant, it is shared by the clients of all its collections
+type Connection struct {
+	client       *qdrant.Client
+	searchConfig QdrantSearchConfig
+}
+
+func Connect(config config.Qdrant) (*Connection, error) {
+	client, err := qdrant.NewClient(&qdrant.Config{
+		Host:   config.Host,
+		Port:   config.Port,
+		APIKey: config.APIKey,
+		UseTLS: config.UseTLS,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Connection{
+		client:       client,
+		searchConfig: NewQdrantSearchConfig(config.Search),
+	}, nil
 }
 
-func close() {
-	if client == nil {
-		return
+// Collection opens the collection and creates it if it does not exist. With truncate, an existing collection is dropped first.
+// dimension is the vector size of the embedding model, vocabulary encodes queries for hybrid search.
+func (c *Connection) Collection(name string, dimension uint64, truncate bool, vocabulary *embedding.Vocabulary) (*VectorDbClient, error) {
+	created, sparse, err := ensureCollection(context.Background(), c.client, name, dimension, truncate, c.searchConfig.Hybrid)
+	if err != nil {
+		return nil, err
 	}
-	if err := client.Close(); err != nil {
+	return &VectorDbClient{
+		client:       c.client,
+		collection:   name,
+		searchConfig: c.searchConfig,
+		vocabulary:   vocabulary,
+		sparse:       sparse,
+		created:      created,
+	}, nil
+}
+
+func (c *Connection) Close() {
+	if err := c.client.Close(); err != nil {
 		log.Printf("Cannot close qdrant client cleanly. %s", err)
 	}
 }
@@ -2,7 +2,9 @@ package vectordb
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/koenighotze/rag-demo/config"
@@ -10,15 +12,60 @@ import (
 	"github.com/qdrant/go-client/qdrant"
 )
 
+// VectorDbClient stores points in a single qdrant collection
 type VectorDbClient struct {
-	client *qdrant.Client
+	client       *qdrant.Client
+	collection   string
+	searchConfig QdrantSearchConfig
+	// vocabulary encodes the query for hybrid search
+	vocabulary *embedding.Vocabulary
+	// sparse is false for collections created before hybrid search, they have no bm25 vector
+	sparse bool
+	// created is set if the collection did not exist or was truncated when the client was opened
+	created bool
 }
 
 type QdrantSearchConfig struct {
 	Exact          bool
 	IndexedOnly    bool
 	ScoreThreshold float32
-	BeamSize       uint64
+	// BeamSize is the hnsw ef, qdrant picks it if it is 0
+	BeamSize uint64
+	// Rescore and Oversampling only have an effect on collections with quantization, qdrant decides if Rescore is nil
+	Rescore      *bool
+	Oversampling float64
+	// Hybrid fuses the dense results with bm25 results, SparseWeight is the share of the bm25 ranking
+	Hybrid       bool
+	SparseWeight float64
+}
+
+// SearchOverrides replace the fields of the search config that are set, e.g. for a single request
+type SearchOverrides struct {
+	Exact          *bool    `json:"exact,omitempty"`
+	IndexedOnly    *bool    `json:"indexed_only,omitempty"`
+	ScoreThreshold *float32 `json:"score_threshold,omitempty"`
+	BeamSize       *uint64  `json:"beam_size,omitempty"`
+	Rescore        *bool    `json:"quantization_rescore,omitempty"`
+	Oversampling   *float64 `json:"oversampling,omitempty"`
+	Hybrid         *bool    `json:"hybrid,omitempty"`
+	SparseWeight   *float64 `json:"sparse_weight,omitempty"`
+}
+
+// Validate checks the ranges of the overrides that are set, a nil override is valid
+func (o *SearchOverrides) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.ScoreThreshold != nil && (*o.ScoreThreshold < -1 || *o.ScoreThreshold > 1) {
+		return fmt.Errorf("search_config.score_threshold must be between -1 and 1, got %g", *o.ScoreThreshold)
+	}
+	if o.Oversampling != nil && *o.Oversampling != 0 && *o.Oversampling < 1 {
+		return fmt.Errorf("search_config.oversampling must be 0 or at least 1, got %g", *o.Oversampling)
+	}
+	if o.SparseWeight != nil && (*o.SparseWeight < 0 || *o.SparseWeight > 1) {
+		return fmt.Errorf("search_config.sparse_weight must be between 0 and 1, got %g", *o.SparseWeight)
+	}
+	return nil
 }
 
 func defaultQdrantSearchConfig() QdrantSearchConfig {
@@ -27,21 +74,67 @@ func defaultQdrantSearchConfig() QdrantSearchConfig {
 		IndexedOnly:    false,
 		BeamSize:       uint64(200),
 		ScoreThreshold: float32(0.3),
+		SparseWeight:   0.5,
 	}
 }
 
+func NewQdrantSearchConfig(search config.Search) QdrantSearchConfig {
+	return QdrantSearchConfig{
+		Exact:          search.Exact,
+		IndexedOnly:    search.IndexedOnly,
+		ScoreThreshold: search.ScoreThreshold,
+		BeamSize:       search.BeamSize,
+		Rescore:        search.Rescore,
+		Oversampling:   search.Oversampling,
+		Hybrid:         search.Hybrid,
+		SparseWeight:   search.SparseWeight,
+	}
+}
+
+// With returns a copy of the config with the overrides applied, nil overrides change nothing
+func (c QdrantSearchConfig) With(overrides *SearchOverrides) QdrantSearchConfig {
+	if overrides == nil {
+		return c
+	}
+	if overrides.Exact != nil {
+		c.Exact = *overrides.Exact
+	}
+	if overrides.IndexedOnly != nil {
+		c.IndexedOnly = *overrides.IndexedOnly
+	}
+	if overrides.ScoreThreshold != nil {
+		c.ScoreThreshold = *overrides.ScoreThreshold
+	}
+	if overrides.BeamSize != nil {
+		c.BeamSize = *overrides.BeamSize
+	}
+	if overrides.Rescore != nil {
+		c.Rescore = overrides.Rescore
+	}
+	if overrides.Oversampling != nil {
+		c.Oversampling = *overrides.Oversampling
+	}
+	if overrides.Hybrid != nil {
+		c.Hybrid = *overrides.Hybrid
+	}
+	if overrides.SparseWeight != nil {
+		c.SparseWeight = *overrides.SparseWeight
+	}
+	return c
+}
+
+// Close releases the collection. The connection stays open for the other collections, it is closed by Connection.Close.
 func (c *VectorDbClient) Close() {
-	close()
 	c.client = nil
 }
 
 func (c *VectorDbClient) AddPointsToCollection(items []*embedding.KnowledgeItem) error {
-	return c.addPointsToCollection(createPointsFromEmbeddings(items))
+	return c.addPointsToCollection(createPointsFromEmbeddings(items, c.sparse))
 }
 
 func (c *VectorDbClient) addPointsToCollection(points []*qdrant.PointStruct) error {
 	result, err := c.client.Upsert(context.Background(), &qdrant.UpsertPoints{
-		CollectionName: config.Default().Qdrant.CollectionName, // TODO This is bad
+		CollectionName: c.collection,
 		Points:         points,
 	})
 	if err != nil {
@@ -53,60 +146,188 @@ func (c *VectorDbClient) addPointsToCollection(points []*qdrant.PointStruct) err
 	return nil
 }
 
-func createPointsFromEmbeddings(items []*embedding.KnowledgeItem) []*qdrant.PointStruct {
+func createPointsFromEmbeddings(items []*embedding.KnowledgeItem, sparse bool) []*qdrant.PointStruct {
 	var points []*qdrant.PointStruct
 	for _, e := range items {
 		points = append(points, &qdrant.PointStruct{
-			Id:      qdrant.NewIDUUID(uuid.New().String()),
-			Vectors: qdrant.NewVectors(e.Embedding...),
+			Id:      qdrant.NewIDUUID(pointId(e)),
+			Vectors: pointVectors(e, sparse),
 			Payload: qdrant.NewValueMap(map[string]any{
-				"path":  e.SourceDocument,
-				"chunk": e.Chunk,
+				pathField:         e.SourceDocument,
+				pathPrefixesField: toAnyList(pathPrefixes(e.SourceDocument)),
+				"title":           e.Title,
+				documentTypeField: e.DocumentType,
+				tagsField:         toAnyList(e.Tags),
+				ingestedAtField:   e.IngestedAt.Unix(),
+				startPageField:    e.StartPage,
+				endPageField:      e.EndPage,
+				"chunk_index":     e.ChunkIndex,
+				"start_offset":    e.StartOffset,
+				"end_offset":      e.EndOffset,
+				"chunk":           e.Chunk,
 			}),
 		})
 	}
 	return points
 }
 
+// pointVectors stores the dense vector as the unnamed default vector and the bm25 weights as a named sparse vector
+// if the collection has one
+func pointVectors(item *embedding.KnowledgeItem, sparse bool) *qdrant.Vectors {
+	if !sparse {
+		return qdrant.NewVectors(item.Embedding...)
+	}
+	vectors := map[string]*qdrant.Vector{
+		denseVectorName: qdrant.NewVectorDense(item.Embedding),
+	}
+	if !item.Sparse.IsEmpty() {
+		vectors[sparseVectorName] = qdrant.NewVectorSparse(item.Sparse.Indices, item.Sparse.Values)
+	}
+	return qdrant.NewVectorsMap(vectors)
+}
+
+// toAnyList converts the list, qdrant.NewValueMap only accepts []any for lists
+func toAnyList(values []string) []any {
+	list := make([]any, 0, len(values))
+	for _, v := range values {
+		list = append(list, v)
+	}
+	return list
+}
+
+func (c *VectorDbClient) DeletePoints(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var pointIds []*qdrant.PointId
+	for _, id := range ids {
+		pointIds = append(pointIds, qdrant.NewIDUUID(id))
+	}
+
+	result, err := c.client.Delete(context.Background(), &qdrant.DeletePoints{
+		CollectionName: c.collection,
+		Points:         qdrant.NewPointsSelector(pointIds...),
+	})
+	if err != nil {
+		return err
+	}
+	if result != nil {
+		log.Printf("Result of deleting points: %s", result.Status)
+	}
+	return nil
+}
+
+func (c *VectorDbClient) CountPoints() (uint64, error) {
+	return c.client.Count(context.Background(), &qdrant.CountPoints{
+		CollectionName: c.collection,
+		Exact:          qdrant.PtrOf(true),
+	})
+}
+
+func pointId(item *embedding.KnowledgeItem) string {
+	if item.Id != "" {
+		return item.Id
+	}
+	return uuid.New().String()
+}
+
 type SearchResult struct {
-	Id    string
+	Id string
+	// Score is the cosine similarity of the dense search, 0 for chunks a hybrid search only found by keywords
 	Score float32
-	Item  embedding.KnowledgeItem
+	// FusedScore ranks the results of hybrid and multi-query searches, it is 0 for a single dense search
+	FusedScore float32
+	Item       embedding.KnowledgeItem
+}
+
+func (c *VectorDbClient) ExecuteSearch(search []float32, limit uint64) ([]*SearchResult, error) {
+	return c.Search(SearchRequest{Vector: search, Limit: limit})
 }
 
-func (c *VectorDbClient) ExecuteSearch(search []float32) ([]*SearchResult, error) {
-	res, err := executeSearch(c.client, search, defaultQdrantSearchConfig())
+func (c *VectorDbClient) Search(request SearchRequest) ([]*SearchResult, error) {
+	searchConfig := c.searchConfig.With(request.Overrides)
+	res, err := executeSearch(c.client, c.collection, request, searchConfig)
 	if err != nil {
 		return nil, err
 	}
+	dense := searchResults(res)
 
+	if searchConfig.Hybrid && !c.sparse {
+		log.Printf("Collection %s has no sparse vector %s, using the dense results only", c.collection, sparseVectorName)
+		return dense, nil
+	}
+	sparse, ok := sparseQuery(c.vocabulary, request, searchConfig)
+	if !ok {
+		return dense, nil
+	}
+	res, err = executeSparseSearch(c.client, c.collection, sparse, request)
+	if err != nil {
+		return nil, err
+	}
+	return fuse(dense, searchResults(res), searchConfig.SparseWeight, request.Limit), nil
+}
+
+func searchResults(points []*qdrant.ScoredPoint) []*SearchResult {
 	var result []*SearchResult
-	for _, r := range res {
+	for _, r := range points {
 		result = append(result, &SearchResult{
 			Id:    r.Id.GetUuid(),
 			Score: r.Score,
-			Item: embedding.KnowledgeItem{
-				Embedding:      r.Vectors.GetVector().Data,
-				Chunk:          r.Payload["chunk"].GetStringValue(),
-				SourceDocument: r.Payload["path"].GetStringValue(),
-			},
+			Item:  knowledgeItemFromPayload(r.Id.GetUuid(), denseVector(r.Vectors), r.Payload),
 		})
 	}
-	return result, nil
+	return result
 }
 
-func DefaultVectorDbClient() *VectorDbClient {
-	return &VectorDbClient{
-		client: defaultClient(),
+// denseVector reads the unnamed vector, qdrant returns it among the named vectors if the point has a sparse vector too
+func denseVector(vectors *qdrant.VectorsOutput) []float32 {
+	vector := vectors.GetVector()
+	if vector == nil {
+		vector = vectors.GetVectors().GetVectors()[denseVectorName]
+	}
+	if dense := vector.GetDense(); dense != nil {
+		return dense.GetData()
 	}
+	return vector.GetData()
 }
 
-func TruncatingVectorDbClient() *VectorDbClient {
-	c, err := newClient(true, config.Default().Qdrant)
-	if err != nil {
-		log.Panic(err)
+func knowledgeItemFromPayload(id string, vector []float32, payload map[string]*qdrant.Value) embedding.KnowledgeItem {
+	item := embedding.KnowledgeItem{
+		Id:             id,
+		Embedding:      vector,
+		Chunk:          payload["chunk"].GetStringValue(),
+		SourceDocument: payload[pathField].GetStringValue(),
+		Title:          payload["title"].GetStringValue(),
+		StartPage:      int(payload[startPageField].GetIntegerValue()),
+		EndPage:        int(payload[endPageField].GetIntegerValue()),
+		ChunkIndex:     int(payload["chunk_index"].GetIntegerValue()),
+		StartOffset:    int(payload["start_offset"].GetIntegerValue()),
+		EndOffset:      int(payload["end_offset"].GetIntegerValue()),
+		DocumentType:   payload[documentTypeField].GetStringValue(),
+	}
+	for _, tag := range payload[tagsField].GetListValue().GetValues() {
+		item.Tags = append(item.Tags, tag.GetStringValue())
 	}
-	return &VectorDbClient{
-		client: c,
+	if ingestedAt, ok := payload[ingestedAtField]; ok {
+		item.IngestedAt = time.Unix(ingestedAt.GetIntegerValue(), 0)
+	}
+
+	// points stored before the position metadata existed only know their page
+	if page, ok := payload["page"]; ok && item.StartPage == 0 {
+		item.StartPage = int(page.GetIntegerValue())
+		item.EndPage = item.StartPage
 	}
+	return item
+}
+
+// Collection is the name of the qdrant collection of this client
+func (c *VectorDbClient) Collection() string {
+	return c.collection
+}
+
+// Created reports whether the collection was created empty when the client was opened, e.g. after it was dropped.
+// Manifests and vocabularies of the previous collection do not apply to it.
+func (c *VectorDbClient) Created() bool {
+	return c.created
 }
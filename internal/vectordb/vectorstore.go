@@ -0,0 +1,34 @@
+package vectordb
+
+import (
+	"github.com/koenighotze/rag-demo/internal/embedding"
+)
+
+// VectorStore is the storage abstraction used by ingestion and querying.
+// VectorDbClient talks to Qdrant, InMemoryVectorStore keeps everything in process.
+type VectorStore interface {
+	AddPointsToCollection(items []*embedding.KnowledgeItem) error
+	ExecuteSearch(search []float32, limit uint64) ([]*SearchResult, error)
+	Search(request SearchRequest) ([]*SearchResult, error)
+	DeletePoints(ids []string) error
+	CountPoints() (uint64, error)
+	Close()
+}
+
+// SearchRequest is a similarity search with optional overrides of the search config
+type SearchRequest struct {
+	Vector []float32
+	// Text is the query the vector was embedded from, hybrid search matches its keywords
+	Text string
+	// Limit is the maximum number of results, a default is used if it is 0
+	Limit     uint64
+	Overrides *SearchOverrides
+	Filter    *Filter
+	// WithVectors returns the dense vectors of the results in Item.Embedding
+	WithVectors bool
+}
+
+var (
+	_ VectorStore = (*VectorDbClient)(nil)
+	_ VectorStore = (*InMemoryVectorStore)(nil)
+)
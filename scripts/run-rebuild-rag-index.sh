@@ -9,4 +9,4 @@ set -o pipefail
 # enable debug mode, by running your script as TRACE=1
 if [[ "${TRACE-0}" == "1" ]]; then set -o xtrace; fi
 
-go run cmd/rag/main.go
+go run cmd/rag/main.go -rebuild